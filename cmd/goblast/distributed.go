@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/lunjon/go-blast/pkg/distributed"
+)
+
+// agentTokenEnv is the environment variable holding the default
+// token of agents and coordinators, which keeps it out of the
+// process list.
+const agentTokenEnv = "GOBLAST_AGENT_TOKEN"
+
+// runServe runs goblast as an agent, waiting for work from a coordinator.
+func runServe(flags *flag.FlagSet, args []string) {
+	listen := flags.String("listen", distributed.DefaultAgentAddress, "The address to listen on, e.g. :7070 for all interfaces.")
+	token := flags.String("token", os.Getenv(agentTokenEnv), "Token the coordinator must send, defaults to $"+agentTokenEnv+".")
+	parse(flags, args)
+
+	listener, err := net.Listen("tcp", *listen)
+	checkError(err, "failed to listen")
+
+	agent := distributed.NewAgent()
+	agent.Token = *token
+
+	fmt.Printf("Agent listening on %s\n", listener.Addr())
+	err = agent.Serve(listener)
+	checkError(err, "agent stopped")
+}
+
+// runCoordinate runs the blast on the agents given by the --agents flag.
+func runCoordinate(flags *flag.FlagSet, args []string) {
+	var opts blastOptions
+	opts.register(flags)
+	agents := flags.String("agents", "", "Comma separated list of agents.")
+	metricsListen := flags.String("metrics-listen", "", "Address to serve Prometheus metrics on, e.g. :9100.")
+	token := flags.String("agent-token", os.Getenv(agentTokenEnv), "Token sent to the agents, defaults to $"+agentTokenEnv+".")
+	sendSecrets := flags.Bool("send-secrets", false, "Send the secrets of the blast, e.g. auth credentials, to the agents in cleartext.")
+	parse(flags, args)
+
+	if *agents == "" {
+		fmt.Println("At least one agent must be provided using --agents")
+		os.Exit(1)
+	}
+
+	coordinator, err := distributed.NewCoordinator(strings.Split(*agents, ","))
+	checkError(err, "failed to create coordinator")
+	coordinator.Token = *token
+	opts.checkBlasters(maxBlasters * len(coordinator.Agents))
+
+	config := opts.configuration()
+	config.SendSecrets = *sendSecrets
+	printConfiguration(config, opts.blasters)
+	fmt.Printf("Agents:\t\t\t%s\n", strings.Join(coordinator.Agents, ", "))
+
+	latest := &latestMetrics{}
+	coordinator.OnUpdate = latest.set
+	if *metricsListen != "" {
+		serveMetrics(latest, *metricsListen)
+	}
+
+	// The agents don't get the metrics sinks, the
+	// combined metrics are pushed from here instead
+	stopMetrics := pushMetrics(latest, config.Metrics)
+
+	start := time.Now()
+	fmt.Printf("Starting:\t\t%s\n", start.Add(coordinator.StartDelay).Format(time.Stamp))
+
+	result, err := coordinator.Run(context.Background(), config, opts.blasters)
+	stopMetrics()
+	checkError(err, "distributed blast failed")
+
+	names := make([]string, 0, len(result.Agents))
+	for agent := range result.Agents {
+		names = append(names, agent)
+	}
+	sort.Strings(names)
+
+	for _, agent := range names {
+		total := result.Agents[agent].Total()
+		fmt.Printf("Agent %s:\t%d/%d successful requests\n", agent, total.Successful, total.Total)
+	}
+
+	printResult(result.Snapshot, opts.blasters, time.Now(), result.Elapsed)
+}
+
+// latestMetrics holds the last combined metrics
+// from the agents, so that they can be served.
+type latestMetrics struct {
+	mu      sync.Mutex
+	metrics blaster.Metrics
+}
+
+func (l *latestMetrics) set(m blaster.Metrics) {
+	l.mu.Lock()
+	l.metrics = m
+	l.mu.Unlock()
+	log.Printf("%d requests sent by %d active blasters", m.Snapshot.Total().Total, m.ActiveBlasters)
+}
+
+func (l *latestMetrics) get() blaster.Metrics {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.metrics
+}
+
+func (l *latestMetrics) Metrics() blaster.Metrics {
+	m := l.get()
+	m.Time = time.Now()
+	m.Snapshot = l.Snapshot()
+	return m
+}
+
+func (l *latestMetrics) Snapshot() blaster.Snapshot {
+	if s := l.get().Snapshot; s.Requests != nil {
+		return s
+	}
+	return blaster.NewSnapshot()
+}
+
+func (l *latestMetrics) TargetRate() float64 {
+	return l.get().TargetRate
+}
+
+func (l *latestMetrics) AchievedRate() float64 {
+	return l.get().AchievedRate
+}
+
+func (l *latestMetrics) ActiveBlasters() int {
+	return l.get().ActiveBlasters
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// runFindMax searches for the highest rate the target can sustain.
+func runFindMax(flags *flag.FlagSet, args []string) {
+	var opts blastOptions
+	opts.register(flags)
+
+	search := blaster.NewCapacitySearch()
+	flags.IntVar(&search.MinRate, "min-rate", blaster.MinRate, "The lowest rate to search.")
+	flags.IntVar(&search.MaxRate, "max-rate", blaster.MaxRate, "The highest rate to search.")
+	flags.IntVar(&search.Step, "step", 0, "Increase the rate by this much each step instead of binary searching.")
+	flags.DurationVar(&search.StepDuration, "step-duration", blaster.DefaultStepDuration, "Time to run each step.")
+	flags.Float64Var(&search.MaxErrorRate, "max-error-rate", blaster.DefaultMaxErrorRate, "Highest share, 0-1, of failed requests allowed.")
+	flags.DurationVar(&search.MaxLatency, "max-latency", 0, "Highest allowed latency at --latency-quantile.")
+	flags.Float64Var(&search.LatencyQuantile, "latency-quantile", blaster.DefaultLatencyQuantile, "Quantile, 0-1, of the latency to evaluate.")
+	flags.Float64Var(&search.MinAchieved, "min-achieved", blaster.DefaultMinAchieved, "Lowest share, 0-1, of the target rate that must be achieved. Zero disables the check.")
+	parse(flags, args)
+
+	opts.checkBlasters(maxBlasters)
+
+	config := opts.configuration()
+	printConfiguration(config, opts.blasters)
+
+	search.Blasters = opts.blasters
+	search.OnStep = func(step blaster.CapacityStep) {
+		result := "passed"
+		if !step.Passed {
+			result = "failed: " + step.Reason
+		}
+		fmt.Printf("Rate %d:\t\t%s\n", step.Rate, result)
+	}
+
+	fmt.Printf("Starting:\t\t%s\n", time.Now().Format(time.Stamp))
+	result, err := blaster.FindMax(config, search)
+	checkError(err, "capacity search failed")
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "RATE\tTARGET (req/s)\tACHIEVED (req/s)\tREQUESTS\tERRORS\tLATENCY\tRESULT")
+	for _, step := range result.Steps {
+		outcome := "pass"
+		if !step.Passed {
+			outcome = "fail"
+		}
+		fmt.Fprintf(w, "%d\t%.0f\t%.1f\t%d\t%.2f%%\t%v\t%s\n",
+			step.Rate,
+			step.TargetRate,
+			step.AchievedRate,
+			step.Total,
+			step.ErrorRate*100,
+			step.Latency.Round(time.Microsecond),
+			outcome)
+	}
+	w.Flush()
+
+	fmt.Println()
+	if result.MaxRate == 0 {
+		fmt.Println("No sustainable rate was found")
+		os.Exit(1)
+	}
+	fmt.Printf("Highest sustainable rate: %d req/s per blaster (%.0f req/s in total)\n", result.MaxRate, result.MaxTotalRate)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// runBlast runs a blast and prints the result.
+func runBlast(flags *flag.FlagSet, args []string) {
+	var opts blastOptions
+	opts.register(flags)
+	metricsListen := flags.String("metrics-listen", "", "Address to serve Prometheus metrics on, e.g. :9100.")
+	controlListen := flags.String("control-listen", "", "Address to serve the run control API on, e.g. localhost:9200.")
+	output := flags.String("output", "", "Filepath to save the result to as JSON, which can be printed again using report.")
+	parse(flags, args)
+
+	opts.checkBlasters(maxBlasters)
+
+	// Parse flags to get configuration
+	config := opts.configuration()
+
+	// Print configuration
+	printConfiguration(config, opts.blasters)
+
+	// Initialize blasters
+	runner, err := blaster.NewRunner(config, opts.blasters)
+	checkError(err, "failed to create blasters")
+	group := runner.Group()
+
+	if *metricsListen != "" {
+		serveMetrics(group, *metricsListen)
+	}
+	if *controlListen != "" {
+		serveControl(group, *controlListen)
+	}
+
+	stopMetrics := pushMetrics(group, config.Metrics)
+
+	// Stop early, but still display the results, on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Starting:\t\t%s\n", time.Now().Format(time.Stamp))
+	result, err := runner.Run(ctx)
+	stopMetrics()
+	if result == nil {
+		checkError(err, "failed to start the blast")
+	}
+	if err != nil {
+		fmt.Println("Interrupted, stopping the blasters")
+	}
+
+	// Display the results
+	printResult(result.Snapshot, result.Blasters, result.End, result.Elapsed)
+	printAnnotations(result.Annotations)
+
+	if *output != "" {
+		b, err := json.MarshalIndent(result, "", "  ")
+		checkError(err, "failed to encode result")
+		err = ioutil.WriteFile(*output, b, 0644)
+		checkError(err, "failed to save result")
+		fmt.Printf("Result saved to %s\n", *output)
+	}
+}
+
+// runValidate checks the configuration of a blast and prints it.
+// The blast file may be given as an argument instead of using --file.
+func runValidate(flags *flag.FlagSet, args []string) {
+	var opts blastOptions
+	opts.register(flags)
+	parse(flags, args)
+
+	if flags.NArg() > 0 {
+		opts.file = flags.Arg(0)
+	}
+	if opts.file != "" && opts.profile == "" {
+		// Without a profile, validate all profiles of the file
+		profiles, err := blaster.ListProfiles(opts.file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if len(profiles) > 0 {
+			validateProfiles(opts.file, profiles)
+			return
+		}
+	}
+	if opts.file != "" {
+		// Print all errors, one per line
+		if _, err := blaster.LoadProfile(opts.file, opts.profile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	opts.checkBlasters(maxBlasters)
+	config := opts.configuration()
+	printConfiguration(config, opts.blasters)
+	fmt.Println("The configuration is valid")
+}
+
+// validateProfiles validates each of the profiles in file.
+func validateProfiles(file string, profiles []string) {
+	valid := true
+	for _, profile := range profiles {
+		if _, err := blaster.LoadProfile(file, profile); err != nil {
+			fmt.Printf("Profile %s is invalid:\n", profile)
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Printf("\t%s\n", line)
+			}
+			valid = false
+		} else {
+			fmt.Printf("Profile %s is valid\n", profile)
+		}
+	}
+
+	if !valid {
+		os.Exit(1)
+	}
+}
+
+// runProfiles lists the profiles of a blast file.
+func runProfiles(flags *flag.FlagSet, args []string) {
+	parse(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	profiles, err := blaster.ListProfiles(flags.Arg(0))
+	checkError(err, "failed to load blast file")
+	for _, profile := range profiles {
+		fmt.Println(profile)
+	}
+}
+
+// runReport prints the result of a blast saved using run --output.
+func runReport(flags *flag.FlagSet, args []string) {
+	parse(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	b, err := ioutil.ReadFile(flags.Arg(0))
+	checkError(err, "failed to read result")
+
+	var result blaster.Result
+	err = json.Unmarshal(b, &result)
+	checkError(err, "failed to decode result")
+
+	fmt.Printf("Started:\t\t%s\n", result.Start.Format(time.Stamp))
+	fmt.Printf("Rate (req/s):\t\t%.1f achieved of %.1f\n", result.AchievedRate, result.TargetRate)
+	printResult(result.Snapshot, result.Blasters, result.End, result.Elapsed)
+	printAnnotations(result.Annotations)
+}
@@ -0,0 +1,365 @@
+package blaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The types of authentication, see AuthConfig.
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthOAuth2 = "oauth2"
+)
+
+// The OAuth2 grants used to get a token, see AuthConfig.
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+)
+
+// AuthConfig configures how the requests are authenticated.
+type AuthConfig struct {
+	// Type is one of AuthBasic, AuthBearer and AuthOAuth2.
+	Type string `json:"type"`
+	// Username and Password are used by AuthBasic, and
+	// by AuthOAuth2 with the password grant.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Token is the static token of AuthBearer.
+	Token string `json:"token,omitempty"`
+	// TokenURL is the token endpoint of AuthOAuth2.
+	TokenURL     string   `json:"token_url,omitempty"`
+	ClientID     string   `json:"client_id,omitempty"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	// Grant is GrantClientCredentials, the default, or GrantPassword.
+	Grant string `json:"grant,omitempty"`
+}
+
+// secrets returns the values of c that must not be printed.
+func (c AuthConfig) secrets() []string {
+	return []string{c.Password, c.Token, c.ClientSecret}
+}
+
+// String describes c without any secrets.
+func (c AuthConfig) String() string {
+	switch c.Type {
+	case AuthBasic:
+		return fmt.Sprintf("basic (%s)", c.Username)
+	case AuthOAuth2:
+		return fmt.Sprintf("oauth2 (%s, %s)", c.Grant, c.TokenURL)
+	default:
+		return c.Type
+	}
+}
+
+// validate sets the defaults of c and returns a *ValidationError
+// with fields such as token_url, or nil.
+func (c *AuthConfig) validate() error {
+	errs := &ValidationError{}
+	required := func(field, value string) {
+		if value == "" {
+			errs.add(field, fmt.Errorf("%s is required by %s authentication", field, c.Type))
+		}
+	}
+
+	switch c.Type {
+	case AuthBasic:
+		required("username", c.Username)
+	case AuthBearer:
+		required("token", c.Token)
+	case AuthOAuth2:
+		required("client_id", c.ClientID)
+		if c.TokenURL == "" {
+			required("token_url", c.TokenURL)
+		} else if _, err := url.ParseRequestURI(c.TokenURL); err != nil {
+			errs.add("token_url", err)
+		}
+
+		if c.Grant == "" {
+			c.Grant = GrantClientCredentials
+		}
+		switch c.Grant {
+		case GrantClientCredentials:
+		case GrantPassword:
+			required("username", c.Username)
+		default:
+			errs.add("grant", fmt.Errorf("unsupported grant %s, use %s or %s", c.Grant, GrantClientCredentials, GrantPassword))
+		}
+	case "":
+		errs.add("type", fmt.Errorf("type is required, use %s, %s or %s", AuthBasic, AuthBearer, AuthOAuth2))
+	default:
+		errs.add("type", fmt.Errorf("unsupported authentication %s, use %s, %s or %s", c.Type, AuthBasic, AuthBearer, AuthOAuth2))
+	}
+	return errs.err()
+}
+
+// NewAuth returns a hook that authenticates each request as
+// configured by c. For AuthOAuth2 the token is shared by all
+// blasters using the hook. It's fetched when the blast is
+// prepared, see Preparer, and refreshed before it expires.
+func NewAuth(c AuthConfig) (Hook, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return newAuth(c), nil
+}
+
+// newAuth returns the hook of a validated AuthConfig.
+func newAuth(c AuthConfig) Hook {
+	switch c.Type {
+	case AuthBasic:
+		return HookFuncs{
+			Before: func(info RequestInfo, req *http.Request) error {
+				req.SetBasicAuth(c.Username, c.Password)
+				return nil
+			},
+		}
+	case AuthBearer:
+		return HookFuncs{
+			Before: func(info RequestInfo, req *http.Request) error {
+				req.Header.Set("Authorization", "Bearer "+c.Token)
+				return nil
+			},
+		}
+	default:
+		return &oauth2Auth{config: c, client: &http.Client{Timeout: tokenTimeout}}
+	}
+}
+
+// AuthError is the error of a request that could not be
+// authenticated, e.g. since no token could be fetched.
+// The request is not sent.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return "auth: " + e.Err.Error()
+}
+
+// Unwrap returns the cause of the error.
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	// maxRefreshMargin is how long before it expires
+	// that a token is refreshed, at most.
+	maxRefreshMargin = time.Minute
+	// tokenRetryDelay is how long to wait before fetching
+	// a token again after failing to.
+	tokenRetryDelay = time.Second
+	// tokenTimeout is how long fetching a token may take.
+	tokenTimeout = 30 * time.Second
+)
+
+// oauth2Auth is a Hook that adds an OAuth2 access token to the
+// requests. Only one token is fetched at a time, in the background,
+// while the current token is used until it expires. Only the
+// blasters without a valid token wait for it.
+type oauth2Auth struct {
+	config AuthConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+	expiry  time.Time
+	// failed is when the last fetch failed, with err
+	failed time.Time
+	err    error
+	// fetching is closed when the current fetch is done,
+	// and nil if no token is being fetched
+	fetching chan struct{}
+}
+
+// Prepare implements Preparer by fetching the first token.
+func (a *oauth2Auth) Prepare(ctx context.Context) error {
+	_, err := a.get(ctx)
+	return err
+}
+
+// BeforeRequest implements Hook.
+func (a *oauth2Auth) BeforeRequest(info RequestInfo, req *http.Request) error {
+	token, err := a.get(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// AfterResponse implements Hook. A token rejected by
+// the target is fetched again by the next request.
+func (a *oauth2Auth) AfterResponse(info RequestInfo, res *http.Response) error {
+	if res.StatusCode != http.StatusUnauthorized || res.Request == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && res.Request.Header.Get("Authorization") == "Bearer "+a.token {
+		log.Printf("Token rejected with %s, fetching a new one", res.Status)
+		a.token = ""
+	}
+	return nil
+}
+
+// get returns the current token, fetching a new one if
+// it's about to expire.
+func (a *oauth2Auth) get(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	now := time.Now()
+	if a.token != "" && (a.refresh.IsZero() || now.Before(a.refresh)) {
+		defer a.mu.Unlock()
+		return a.token, nil
+	}
+	if now.Sub(a.failed) < tokenRetryDelay {
+		defer a.mu.Unlock()
+		return a.current(now)
+	}
+
+	if a.fetching == nil {
+		a.fetching = make(chan struct{})
+		go a.update()
+	}
+	// The token is used while the new one is fetched
+	if a.token != "" && now.Before(a.expiry) {
+		defer a.mu.Unlock()
+		return a.token, nil
+	}
+	fetching := a.fetching
+	a.mu.Unlock()
+
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return "", &AuthError{Err: ctx.Err()}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.current(time.Now())
+}
+
+// update fetches a new token and closes a.fetching when done. The
+// fetch isn't canceled with the request that started it, since
+// the token is shared, but it's limited by tokenTimeout.
+func (a *oauth2Auth) update() {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenTimeout)
+	defer cancel()
+	token, lifetime, err := a.fetch(ctx)
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer func() {
+		close(a.fetching)
+		a.fetching = nil
+	}()
+
+	if err != nil {
+		log.Printf("Failed to fetch token: %v", err)
+		a.failed, a.err = now, err
+		return
+	}
+
+	a.token, a.err = token, nil
+	a.refresh, a.expiry = time.Time{}, time.Time{}
+	if lifetime > 0 {
+		margin := lifetime / 5
+		if margin > maxRefreshMargin {
+			margin = maxRefreshMargin
+		}
+		a.expiry = now.Add(lifetime)
+		a.refresh = a.expiry.Add(-margin)
+	}
+	log.Printf("Fetched token from %s, expires in %v", a.config.TokenURL, lifetime)
+}
+
+// current returns the token if it's still valid, even though it
+// should have been refreshed, and otherwise the last error.
+func (a *oauth2Auth) current(now time.Time) (string, error) {
+	if a.token != "" && (a.expiry.IsZero() || now.Before(a.expiry)) {
+		return a.token, nil
+	}
+	if a.err == nil {
+		return "", &AuthError{Err: fmt.Errorf("the token was rejected")}
+	}
+	return "", &AuthError{Err: a.err}
+}
+
+// fetch requests a new token from the token endpoint,
+// and returns it together with its lifetime, if given.
+func (a *oauth2Auth) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {a.config.Grant}}
+	if len(a.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.config.Scopes, " "))
+	}
+	if a.config.Grant == GrantPassword {
+		form.Set("username", a.config.Username)
+		form.Set("password", a.config.Password)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// The client credentials are form encoded, see RFC 6749 section 2.3.1
+	req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	jsonErr := json.Unmarshal(b, &token)
+
+	if res.StatusCode >= 300 {
+		msg := fmt.Sprintf("token endpoint responded %s", res.Status)
+		if token.Error != "" {
+			msg += ": " + token.Error
+		}
+		if token.ErrorDescription != "" {
+			msg += ": " + token.ErrorDescription
+		}
+		return "", 0, fmt.Errorf("%s", msg)
+	}
+	if jsonErr != nil {
+		return "", 0, fmt.Errorf("invalid token response: %v", jsonErr)
+	}
+	if token.AccessToken == "" {
+		return "", 0, fmt.Errorf("the token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %s", token.TokenType)
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,187 @@
+package blaster
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// The strategies distributing the requests over the
+// targets, see TargetsConfig.
+const (
+	BalanceRoundRobin = "round_robin"
+	BalanceRandom     = "random"
+	BalanceHash       = "hash"
+)
+
+// DefaultHashKey is the default key of BalanceHash, sending
+// all requests of a blaster to the same target.
+const DefaultHashKey = "{{.BlasterID}}"
+
+// TargetsConfig configures several targets, e.g. the instances of
+// a fleet, that the requests are distributed over.
+type TargetsConfig struct {
+	// URLs of the targets.
+	URLs []string `json:"urls"`
+	// Strategy is BalanceRoundRobin, the default, BalanceRandom
+	// or BalanceHash.
+	Strategy string `json:"strategy,omitempty"`
+	// HashKey is a template rendered with the RequestInfo of each
+	// request, e.g. {{.BlasterID}}, the default. BalanceHash sends
+	// the requests with the same key to the same target, also
+	// when targets are added or removed, as far as possible.
+	HashKey string `json:"hash_key,omitempty"`
+}
+
+// String describes c.
+func (c TargetsConfig) String() string {
+	s := fmt.Sprintf("%d targets, %s", len(c.URLs), c.Strategy)
+	if c.Strategy == BalanceHash {
+		s += " by " + c.HashKey
+	}
+	return s
+}
+
+// validate sets the defaults of c and returns its balancer, or a
+// *ValidationError with fields such as urls[0] and strategy.
+func (c *TargetsConfig) validate() (*balancer, error) {
+	errs := &ValidationError{}
+	b := &balancer{}
+	if len(c.URLs) == 0 {
+		errs.add("urls", fmt.Errorf("at least one target URL is required"))
+	}
+	for i, u := range c.URLs {
+		field := fmt.Sprintf("urls[%d]", i)
+		if strings.HasPrefix(u, unixScheme) {
+			errs.add(field, fmt.Errorf("a Unix domain socket can't be one of several targets"))
+			continue
+		}
+
+		target, err := url.ParseRequestURI(u)
+		errs.add(field, err)
+		b.targets = append(b.targets, target)
+	}
+
+	if c.Strategy == "" {
+		c.Strategy = BalanceRoundRobin
+	}
+	switch c.Strategy {
+	case BalanceRoundRobin, BalanceRandom:
+	case BalanceHash:
+		if c.HashKey == "" {
+			c.HashKey = DefaultHashKey
+		}
+		var err error
+		b.key, err = ParseTemplate(c.HashKey)
+		errs.add("hash_key", err)
+	default:
+		errs.add("strategy", fmt.Errorf("unsupported strategy %s, use %s, %s or %s", c.Strategy, BalanceRoundRobin, BalanceRandom, BalanceHash))
+	}
+
+	b.strategy = c.Strategy
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ReadHosts reads a file of hosts, one per line, and returns the URLs
+// of the hosts, i.e. base with its host replaced. A line may also be a
+// base URL, e.g. https://10.0.0.1:8443, to replace the scheme as well.
+// Empty lines and lines starting with # are ignored.
+func ReadHosts(filename, base string) ([]string, error) {
+	u, err := url.ParseRequestURI(base)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	urls := []string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		host := strings.TrimSpace(scanner.Text())
+		if host == "" || strings.HasPrefix(host, "#") {
+			continue
+		}
+
+		target := *u
+		if strings.Contains(host, "://") {
+			h, err := url.Parse(host)
+			if err != nil || h.Host == "" {
+				return nil, fmt.Errorf("%s:%d: invalid host %s", filename, line, host)
+			}
+			target.Scheme, target.Host = h.Scheme, h.Host
+		} else {
+			target.Host = host
+		}
+		urls = append(urls, target.String())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s: no hosts in the file", filename)
+	}
+	return urls, nil
+}
+
+// balancer picks the target of each request.
+type balancer struct {
+	// next is the index of the next round-robin target, first
+	// in the struct to be aligned for atomic operations
+	next     uint64
+	targets  []*url.URL
+	strategy string
+	key      *Template
+}
+
+// pick returns the target of a request.
+func (b *balancer) pick(info RequestInfo) (*url.URL, error) {
+	switch b.strategy {
+	case BalanceRandom:
+		return b.targets[rand.Intn(len(b.targets))], nil
+	case BalanceHash:
+		key, err := b.key.Render(info)
+		if err != nil {
+			return nil, fmt.Errorf("hash_key: %v", err)
+		}
+		return b.hash(key), nil
+	default:
+		i := atomic.AddUint64(&b.next, 1) - 1
+		return b.targets[i%uint64(len(b.targets))], nil
+	}
+}
+
+// hash returns the target of key using rendezvous hashing, i.e. the
+// target with the highest hash of the key and the target, so that
+// only the keys of a removed target move to other targets.
+func (b *balancer) hash(key string) *url.URL {
+	var best *url.URL
+	var highest uint64
+	for _, t := range b.targets {
+		h := fnv.New64a()
+		h.Write([]byte(t.String()))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+
+		// Mix the bits, since FNV of similar strings are similar
+		sum := h.Sum64()
+		sum ^= sum >> 33
+		sum *= 0xff51afd7ed558ccd
+		sum ^= sum >> 33
+		if best == nil || sum > highest {
+			best, highest = t, sum
+		}
+	}
+	return best
+}
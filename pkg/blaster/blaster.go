@@ -1,8 +1,12 @@
 package blaster
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"sync"
 	"time"
 
@@ -12,18 +16,25 @@ import (
 // Blaster represents a so called blaster, it runs for a given duration
 // and each time it's ticker emits a value.
 type Blaster struct {
+	mu      sync.Mutex
 	running bool
 	id      string
 
-	config *Configuration
-	ticker   *time.Ticker
-	httpClient  *http.Client
+	config    *Configuration
+	iteration int
+	period    time.Duration
+	duration  time.Duration
+	paused    bool
+	periods   chan time.Duration
+	session   *session
 
 	// For the report
-	totalRequests      int
-	successfulRequests int
+	stats    *stats
+	onResult func(RequestResult)
+	// onDone, if set, is called when the blaster is done
+	onDone func()
 
-	done chan bool
+	stop chan struct{}
 	wg   *sync.WaitGroup
 }
 
@@ -37,100 +48,339 @@ func NewBlaster(id string, config *Configuration, wg *sync.WaitGroup) (*Blaster,
 		return nil, err
 	}
 
-	done := make(chan bool)
 	return &Blaster{
 		id:       id,
-		config: config,
-		ticker:   time.NewTicker(period),
-		httpClient:  &http.Client{},
+		config:   config,
+		period:   period,
+		duration: config.Duration,
+		periods:  make(chan time.Duration, 1),
+		session:  newSession(config),
+		stats:    newStats(),
 		wg:       wg,
-		done:     done}, nil
+		stop:     make(chan struct{})}, nil
+}
+
+// ID returns the id of the blaster.
+func (b *Blaster) ID() string {
+	return b.id
 }
 
 // Start is a non-blocking call that will start the blaster.
 func (b *Blaster) Start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	if b.running {
 		log.Printf("Blaster %s is already running", b.id)
 		return
 	}
 
-	go func() {
-		time.Sleep(b.config.Duration)
-		b.done <- true
-	}()
-
+	b.running = true
 	go run(b)
 	log.Printf("Blaster %s started", b.id)
 }
 
 // Signal stop to the blaster.
 func (b *Blaster) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	if !b.running {
 		log.Printf("Blaster %s is not running", b.id)
 		return
 	}
 
-	go func() {
-		b.done <- true
-	}()
+	b.running = false
+	close(b.stop)
 	log.Printf("Blaster %s was signaled to stop", b.id)
 }
 
+// Running returns true if the blaster has been started
+// and has not yet finished.
+func (b *Blaster) Running() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.running
+}
+
+// Pause makes the blaster stop sending requests until resumed.
+// The blaster keeps running, i.e. the duration is not extended.
+func (b *Blaster) Pause() {
+	b.mu.Lock()
+	b.paused = true
+	b.mu.Unlock()
+}
+
+// Resume makes a paused blaster send requests again.
+func (b *Blaster) Resume() {
+	b.mu.Lock()
+	b.paused = false
+	b.mu.Unlock()
+}
+
+// Paused returns true if the blaster is paused.
+func (b *Blaster) Paused() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.paused
+}
+
+// SetRate changes the request rate of the blaster,
+// also while it's running.
+func (b *Blaster) SetRate(rate int) error {
+	period, err := util.TimeFromFrequency(float64(rate))
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.period = period
+
+	// Replace any pending change that has not yet been applied
+	select {
+	case <-b.periods:
+	default:
+	}
+	b.periods <- period
+	return nil
+}
+
 // TotalRequests returns the current count of the total requests sent.
 func (b *Blaster) TotalRequests() int {
-	return b.totalRequests
+	return b.Snapshot().Total().Total
 }
 
 // SuccessfulRequests returns the current count of the number of
 // successful requests sent. A successful request has a response
 // status code less than 400.
 func (b *Blaster) SuccessfulRequests() int {
-	return b.successfulRequests
+	return b.Snapshot().Total().Successful
+}
+
+// Snapshot returns a copy of the current statistics of the blaster.
+func (b *Blaster) Snapshot() Snapshot {
+	return b.stats.copy()
 }
 
 func run(b *Blaster) {
-	b.running = true
 	defer b.wg.Done()
-	defer b.ticker.Stop()
+	if b.onDone != nil {
+		defer b.onDone()
+	}
+	defer b.session.close()
+
+	// ctx is done when the blaster stops, which lets
+	// a virtual user interrupt what it's waiting for
+	ctx, cancel := context.WithTimeout(context.Background(), b.duration)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var vu VU
+	if b.config.Scenario != nil {
+		var err error
+		vu, err = b.config.Scenario.NewVU(b.vuContext(ctx.Done()))
+		if err != nil {
+			log.Printf("Blaster %s failed to create virtual user: %v", b.id, err)
+			b.record(RequestResult{
+				BlasterID: b.id,
+				Name:      ScenarioRequestName,
+				Time:      time.Now(),
+				Err:       &ScenarioError{Err: err},
+			})
+			b.mu.Lock()
+			b.running = false
+			b.mu.Unlock()
+			return
+		}
+		defer vu.Close()
+	}
+
+	// The connections are opened before the first message
+	var ws *wsClient
+	if b.config.websocket != nil && vu == nil {
+		ws = newWSClient(b, RequestInfo{BlasterID: b.id, Name: b.config.Name})
+		defer ws.close()
+	}
+	var rpc *grpcClient
+	if b.config.caller != nil && vu == nil {
+		rpc = newGRPCClient(b)
+		defer rpc.close()
+	}
+
+	b.mu.Lock()
+	ticker := time.NewTicker(b.period)
+	b.mu.Unlock()
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(b.duration)
+	defer timeout.Stop()
 
 	for {
 		select {
-		case <-b.done:
+		case <-b.stop:
+			return
+		case period := <-b.periods:
+			ticker.Reset(period)
+		case <-timeout.C:
+			b.mu.Lock()
 			b.running = false
+			b.mu.Unlock()
 			return
-		case <-b.ticker.C:
-			res, err := b.send()
-			if err != nil {
-				log.Fatalf("Blaster %s failed during send: %T: %v", b.id, err, err)
+		case <-ticker.C:
+			// A slow iteration may have outlived the blaster
+			if ctx.Err() != nil {
+				b.mu.Lock()
+				b.running = false
+				b.mu.Unlock()
+				return
+			}
+			if b.Paused() {
+				continue
 			}
 
-			if res.StatusCode < 400 {
-				b.successfulRequests++
+			b.session.next(b.iteration)
+			info := RequestInfo{
+				BlasterID: b.id,
+				Iteration: b.iteration,
+				Session:   b.session.resets,
+				Name:      b.config.Name,
 			}
+			b.iteration++
 
-			b.totalRequests++
+			switch {
+			case vu != nil:
+				b.iterate(vu, info)
+			case ws != nil:
+				ws.send(info)
+			case rpc != nil:
+				rpc.send(info)
+			default:
+				b.send(info)
+			}
 		}
 	}
 }
 
-func (b *Blaster) send() (*http.Response, error) {
-	req, err := b.config.BuildRequest()
+// record adds the result to the statistics of the blaster.
+func (b *Blaster) record(result RequestResult) {
+	if result.Err != nil {
+		log.Printf("Blaster %s failed during send: %T: %v", b.id, result.Err, result.Err)
+	}
+
+	b.stats.record(result)
+	if b.onResult != nil {
+		b.onResult(result)
+	}
+}
+
+// send sends the configured request.
+func (b *Blaster) send(info RequestInfo) {
+	req, err := b.config.BuildRequestFor(info)
 	if err != nil {
-		return nil, err
+		b.record(RequestResult{
+			BlasterID: b.id,
+			Name:      info.Name,
+			Time:      time.Now(),
+			Err:       err,
+		})
+		return
+	}
+
+	b.do(info, req, false)
+}
+
+// iterate runs one iteration of the virtual user.
+func (b *Blaster) iterate(vu VU, info RequestInfo) {
+	start := time.Now()
+	if err := vu.Iterate(info); err != nil {
+		b.record(RequestResult{
+			BlasterID: b.id,
+			Name:      ScenarioRequestName,
+			Time:      start,
+			Err:       &ScenarioError{Err: err},
+			Latency:   time.Since(start),
+		})
 	}
+}
 
+func (b *Blaster) vuContext(done <-chan struct{}) VUContext {
+	return VUContext{
+		BlasterID: b.id,
+		Config:    b.config,
+		Do: func(info RequestInfo, req *http.Request) (RequestResult, *http.Response, []byte) {
+			return b.do(info, req, true)
+		},
+		Check: b.stats.check,
+		Done:  done,
+	}
+}
+
+// do sends req through the hooks and records the result. The
+// response body is returned if keepBody is true, otherwise it's
+// discarded.
+func (b *Blaster) do(info RequestInfo, req *http.Request, keepBody bool) (result RequestResult, res *http.Response, body []byte) {
+	result.BlasterID = b.id
+	result.Name = info.Name
+	result.Host = req.URL.Host
 	start := time.Now()
-	res, err := b.httpClient.Do(req)
-	elapsed := time.Since(start)
+	result.Time = start
+	defer func() {
+		result.Latency = time.Since(start)
+		b.record(result)
+	}()
+
+	if err := beforeRequest(b.config.requestHooks(), info, req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		result.Err = err
+		return
+	}
+
+	t := &tracer{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.clientTrace()))
+
+	// Count the bytes of the body actually sent
+	if req.Body != nil && req.Body != http.NoBody {
+		counter := &countingReader{ReadCloser: req.Body}
+		req.Body = counter
+		defer func() { result.BytesSent = counter.count() }()
+	}
+
+	res, err := b.session.client.Do(req)
 	if err != nil {
-		return nil, err
+		result.Err = err
+		return
 	}
 
+	hookErr := afterResponse(b.config.requestHooks(), info, res)
+
+	// Read the whole body so that the connection can be reused
+	if keepBody {
+		body, err = ioutil.ReadAll(res.Body)
+	} else {
+		_, err = io.Copy(ioutil.Discard, res.Body)
+	}
+	res.Body.Close()
+	t.bodyRead()
+
+	result.StatusCode = res.StatusCode
+	result.Protocol = res.Proto
+	result.Timing = t.result()
+	result.Err = err
+	if hookErr != nil {
+		result.Err = hookErr
+	}
 	log.Printf(
 		"%s %s: %s (%v ms)",
 		req.Method,
 		req.URL.String(),
 		res.Status,
-		elapsed)
-	return res, err
+		time.Since(start))
+	return
 }
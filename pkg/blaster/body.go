@@ -0,0 +1,248 @@
+package blaster
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Body produces a new request body for each request, e.g. one that
+// is too large to keep in memory or that differs between requests.
+type Body interface {
+	// Open returns the body of a request, its content type and
+	// its length, or -1 if the length is unknown.
+	Open(info RequestInfo) (body io.ReadCloser, contentType string, length int64, err error)
+}
+
+// MultipartField is a text field of a multipart body.
+type MultipartField struct {
+	Name string
+	// Value is a Template.
+	Value string
+}
+
+// MultipartFile is a file part of a multipart body.
+type MultipartFile struct {
+	// Name is the name of the form field.
+	Name string
+	// Path is the path of the file to send.
+	Path string
+	// Filename is a Template, and defaults to the base name of Path.
+	Filename string
+	// ContentType defaults to the type given by the
+	// extension of Path, or application/octet-stream.
+	ContentType string
+}
+
+// MultipartBody is a multipart/form-data body. The text fields and
+// file names are rendered for each request, and the files are read
+// while the request is sent rather than kept in memory.
+type MultipartBody struct {
+	fields []multipartField
+	files  []multipartFile
+}
+
+type multipartField struct {
+	name  string
+	value *Template
+}
+
+type multipartFile struct {
+	MultipartFile
+	filename *Template
+}
+
+// NewMultipartBody creates a multipart body of the fields, followed
+// by the files. The returned error is a *ValidationError, with
+// fields such as files[0].path.
+func NewMultipartBody(fields []MultipartField, files []MultipartFile) (*MultipartBody, error) {
+	m := &MultipartBody{}
+	errs := &ValidationError{}
+
+	for i, f := range fields {
+		field := fmt.Sprintf("fields[%d]", i)
+		if f.Name == "" {
+			errs.add(field+".name", fmt.Errorf("the name of a field is required"))
+		}
+		value, err := ParseTemplate(f.Value)
+		errs.add(field+".value", err)
+		m.fields = append(m.fields, multipartField{name: f.Name, value: value})
+	}
+
+	for i, f := range files {
+		field := fmt.Sprintf("files[%d]", i)
+		if f.Name == "" {
+			errs.add(field+".name", fmt.Errorf("the name of a file field is required"))
+		}
+		if info, err := os.Stat(f.Path); err != nil {
+			errs.add(field+".path", err)
+		} else if !info.Mode().IsRegular() {
+			errs.add(field+".path", fmt.Errorf("%s is not a file", f.Path))
+		}
+
+		if f.Filename == "" {
+			f.Filename = filepath.Base(f.Path)
+		}
+		if f.ContentType == "" {
+			f.ContentType = mime.TypeByExtension(filepath.Ext(f.Path))
+		}
+		if f.ContentType == "" {
+			f.ContentType = "application/octet-stream"
+		}
+		filename, err := ParseTemplate(f.Filename)
+		errs.add(field+".filename", err)
+		m.files = append(m.files, multipartFile{MultipartFile: f, filename: filename})
+	}
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// multipartPart is a part of a rendered multipart body.
+type multipartPart struct {
+	header textproto.MIMEHeader
+	value  string
+	// path and size are set for files
+	path string
+	size int64
+}
+
+// Open implements Body. The body is written by a goroutine as
+// it's read, and its length is computed up front from the size
+// of the files, so that the request isn't chunked.
+func (m *MultipartBody) Open(info RequestInfo) (io.ReadCloser, string, int64, error) {
+	parts, err := m.render(info)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	// The length is the size of the body with the files left out,
+	// plus the sizes of the files
+	counter := &countingWriter{}
+	w := multipart.NewWriter(counter)
+	if err := writeParts(w, parts, func(io.Writer, multipartPart) error { return nil }); err != nil {
+		return nil, "", 0, err
+	}
+	length := counter.n
+	for _, p := range parts {
+		length += p.size
+	}
+
+	pr, pw := io.Pipe()
+	streamed := multipart.NewWriter(pw)
+	streamed.SetBoundary(w.Boundary())
+	go func() {
+		pw.CloseWithError(writeParts(streamed, parts, copyFile))
+	}()
+
+	return pr, streamed.FormDataContentType(), length, nil
+}
+
+// render renders the parts for a request.
+func (m *MultipartBody) render(info RequestInfo) ([]multipartPart, error) {
+	parts := make([]multipartPart, 0, len(m.fields)+len(m.files))
+	for _, f := range m.fields {
+		value, err := f.value.Render(info)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.name, err)
+		}
+
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(f.name)))
+		parts = append(parts, multipartPart{header: h, value: value})
+	}
+
+	for _, f := range m.files {
+		filename, err := f.filename.Render(info)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %v", f.Name, err)
+		}
+		stat, err := os.Stat(f.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(f.Name), escapeQuotes(filename)))
+		h.Set("Content-Type", f.ContentType)
+		parts = append(parts, multipartPart{header: h, path: f.Path, size: stat.Size()})
+	}
+	return parts, nil
+}
+
+// writeParts writes the parts to w, using writeFile to write the
+// content of the files, and closes w.
+func writeParts(w *multipart.Writer, parts []multipartPart, writeFile func(io.Writer, multipartPart) error) error {
+	for _, p := range parts {
+		pw, err := w.CreatePart(p.header)
+		if err != nil {
+			return err
+		}
+
+		if p.path != "" {
+			err = writeFile(pw, p)
+		} else {
+			_, err = io.WriteString(pw, p.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func copyFile(w io.Writer, p multipartPart) error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Send exactly the size the length was computed from
+	n, err := io.Copy(w, io.LimitReader(f, p.size))
+	if err == nil && n != p.size {
+		err = fmt.Errorf("%s changed size while being sent", p.path)
+	}
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += int64(len(b))
+	return len(b), nil
+}
+
+// countingReader counts the bytes read from a request body. The
+// body may still be read by the transport after the response is
+// received, so the count is updated atomically.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func (r *countingReader) count() int64 {
+	return atomic.LoadInt64(&r.n)
+}
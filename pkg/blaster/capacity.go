@@ -0,0 +1,231 @@
+package blaster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultStepDuration is the default time to run each step of a capacity search.
+	DefaultStepDuration = 10 * time.Second
+	// DefaultMaxErrorRate is the default share of failed requests allowed in a step.
+	DefaultMaxErrorRate = 0.01
+	// DefaultLatencyQuantile is the default quantile of the latency that is evaluated.
+	DefaultLatencyQuantile = 0.99
+	// DefaultMinAchieved is the default share of the target rate that must be achieved.
+	DefaultMinAchieved = 0.9
+)
+
+// CapacitySearch describes how to search for the highest
+// sustainable rate of a target. Use NewCapacitySearch to get
+// one with the default SLOs.
+type CapacitySearch struct {
+	// Blasters is the number of blasters used in each step.
+	Blasters int
+	// MinRate and MaxRate bound the rate, per blaster, to search.
+	MinRate int
+	MaxRate int
+	// Step is the increase of the rate between each step. If zero
+	// the rate is binary searched instead of stepped linearly.
+	Step int
+	// StepDuration is how long to run each step.
+	StepDuration time.Duration
+
+	// MaxErrorRate is the share of requests, 0-1, that may fail
+	// (error or a status code of 400 or above) in a step. Zero
+	// means that no request may fail.
+	MaxErrorRate float64
+	// MaxLatency is the highest allowed latency at LatencyQuantile.
+	// Zero means that the latency is not evaluated.
+	MaxLatency      time.Duration
+	LatencyQuantile float64
+	// MinAchieved is the share of the target rate, 0-1, that must
+	// be achieved for a step to pass. Zero means that the achieved
+	// rate is not evaluated.
+	MinAchieved float64
+
+	// OnStep, if set, is called after each step is done.
+	OnStep func(CapacityStep)
+}
+
+// NewCapacitySearch returns a search over all rates, using the
+// default step duration and SLOs.
+func NewCapacitySearch() CapacitySearch {
+	return CapacitySearch{
+		Blasters:        1,
+		MinRate:         MinRate,
+		MaxRate:         MaxRate,
+		StepDuration:    DefaultStepDuration,
+		MaxErrorRate:    DefaultMaxErrorRate,
+		LatencyQuantile: DefaultLatencyQuantile,
+		MinAchieved:     DefaultMinAchieved,
+	}
+}
+
+// CapacityStep is the outcome of a single step in a capacity search.
+type CapacityStep struct {
+	Rate         int
+	TargetRate   float64
+	AchievedRate float64
+	Total        int
+	Successful   int
+	ErrorRate    float64
+	Latency      time.Duration
+	Passed       bool
+	// Reason describes why the step failed, if it did.
+	Reason string
+}
+
+// CapacityResult is the outcome of a capacity search.
+type CapacityResult struct {
+	// MaxRate is the highest passing rate per blaster,
+	// or zero if no rate passed.
+	MaxRate int
+	// MaxTotalRate is the highest passing rate of all blasters.
+	MaxTotalRate float64
+	Steps        []CapacityStep
+}
+
+// FindMax searches for the highest rate that the target described by
+// config can sustain without violating the SLOs of search. Each step
+// runs a new group of blasters, using config with another rate and
+// duration.
+func FindMax(config *Configuration, search CapacitySearch) (*CapacityResult, error) {
+	search = withCapacityDefaults(search)
+	if search.MinRate < MinRate || search.MaxRate > MaxRate || search.MinRate > search.MaxRate {
+		return nil, fmt.Errorf("rates to search must be in the range %d-%d", MinRate, MaxRate)
+	}
+	if search.Step < 0 {
+		return nil, fmt.Errorf("step must not be negative")
+	}
+	if search.MaxErrorRate < 0 || search.MaxErrorRate > 1 {
+		return nil, fmt.Errorf("max error rate must be in the range 0-1")
+	}
+	if search.MinAchieved < 0 || search.MinAchieved > 1 {
+		return nil, fmt.Errorf("min achieved must be in the range 0-1")
+	}
+
+	result := &CapacityResult{}
+	runStep := func(rate int) (bool, error) {
+		step, err := runCapacityStep(config, search, rate)
+		if err != nil {
+			return false, err
+		}
+
+		result.Steps = append(result.Steps, step)
+		if step.Passed && rate > result.MaxRate {
+			result.MaxRate = rate
+			result.MaxTotalRate = step.TargetRate
+		}
+		if search.OnStep != nil {
+			search.OnStep(step)
+		}
+		return step.Passed, nil
+	}
+
+	if search.Step > 0 {
+		for rate := search.MinRate; rate <= search.MaxRate; rate += search.Step {
+			passed, err := runStep(rate)
+			if err != nil {
+				return nil, err
+			}
+			if !passed {
+				break
+			}
+		}
+		return result, nil
+	}
+
+	// Binary search where lo is the highest known passing
+	// rate and hi the lowest known failing rate.
+	lo, hi := search.MinRate-1, search.MaxRate+1
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		passed, err := runStep(mid)
+		if err != nil {
+			return nil, err
+		}
+		if passed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return result, nil
+}
+
+// withCapacityDefaults sets the fields of search where zero isn't
+// a valid value. MaxErrorRate and MinAchieved are kept as given
+// since zero is meaningful, their defaults are set by NewCapacitySearch.
+func withCapacityDefaults(search CapacitySearch) CapacitySearch {
+	if search.Blasters == 0 {
+		search.Blasters = 1
+	}
+	if search.MinRate == 0 {
+		search.MinRate = MinRate
+	}
+	if search.MaxRate == 0 {
+		search.MaxRate = MaxRate
+	}
+	if search.StepDuration == 0 {
+		search.StepDuration = DefaultStepDuration
+	}
+	if search.LatencyQuantile == 0 {
+		search.LatencyQuantile = DefaultLatencyQuantile
+	}
+	return search
+}
+
+func runCapacityStep(config *Configuration, search CapacitySearch, rate int) (CapacityStep, error) {
+	c := *config
+	c.Duration = search.StepDuration
+	if err := c.SetRate(rate); err != nil {
+		return CapacityStep{}, err
+	}
+
+	runner, err := NewRunner(&c, search.Blasters)
+	if err != nil {
+		return CapacityStep{}, err
+	}
+
+	log.Printf("Running capacity step with rate %d", rate)
+	result, err := runner.Run(context.Background())
+	if err != nil {
+		return CapacityStep{}, err
+	}
+
+	total := result.Snapshot.Total()
+	step := CapacityStep{
+		Rate:         rate,
+		TargetRate:   result.TargetRate,
+		AchievedRate: result.AchievedRate,
+		Total:        total.Total,
+		Successful:   total.Successful,
+		Latency:      total.Latency.Quantile(search.LatencyQuantile),
+	}
+	if total.Total > 0 {
+		step.ErrorRate = float64(total.Total-total.Successful) / float64(total.Total)
+	}
+
+	// Allow each blaster to miss one tick since the
+	// first request is sent one period after start.
+	expected := search.Blasters * (int(float64(rate)*search.StepDuration.Seconds()) - 1)
+
+	switch {
+	case total.Total == 0:
+		step.Reason = "no requests sent"
+	case step.ErrorRate > search.MaxErrorRate:
+		step.Reason = fmt.Sprintf("error rate %.2f%% above %.2f%%", step.ErrorRate*100, search.MaxErrorRate*100)
+	case search.MaxLatency > 0 && step.Latency > search.MaxLatency:
+		step.Reason = fmt.Sprintf("p%g latency %v above %v", search.LatencyQuantile*100, step.Latency, search.MaxLatency)
+	case float64(total.Total) < float64(expected)*search.MinAchieved:
+		step.Reason = fmt.Sprintf("achieved rate %.1f req/s below %.0f%% of the target", step.AchievedRate, search.MinAchieved*100)
+	default:
+		step.Passed = true
+	}
+
+	return step, nil
+}
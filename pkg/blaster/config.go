@@ -2,9 +2,11 @@ package blaster
 
 import (
     "bytes"
+    "encoding/json"
     "fmt"
     "io"
     "log"
+    "net"
     "net/http"
     "net/url"
     "strings"
@@ -26,6 +28,9 @@ const (
     MaxDuration = 900 // 900 seconds = 15 minutes
 )
 
+// minSecretLength is the length of the shortest secret that is masked.
+const minSecretLength = 4
+
 var (
     supportedHTTPMethods = []string{
         http.MethodGet,
@@ -36,12 +41,50 @@ var (
 
 // Configuration holds the configuration for a blast
 type Configuration struct {
+    // Name of the request, used when reporting statistics.
+    // Defaults to the HTTP method and URL path.
+    Name        string
+    // Profile is the profile of the blast file used, if any.
+    Profile     string
     Rate        int
     Duration    time.Duration
     URL         *url.URL
     HTTPMethod  string
     Header      http.Header
+    // Metrics configures where to push metrics during the blast.
+    Metrics     MetricsConfig
+    // Script is the path to a scenario script given in a blast
+    // file, see package script. It's not loaded by LoadFile.
+    Script      string
+    // Scenario, if set, is run by each blaster instead
+    // of sending the configured request.
+    Scenario    Scenario
+    // SendSecrets lets MarshalJSON include the secrets of the
+    // configuration, e.g. the credentials of auth, which are
+    // otherwise never sent to another process.
+    SendSecrets bool
+    hooks       []Hook
+    auth        *AuthConfig
+    signing     *SigningConfig
+    jwt         *JWTConfig
+    session     *SessionConfig
+    socket      string
+    resolve     map[string]string
+    targets     *TargetsConfig
+    proxy       *ProxyConfig
+    http        *HTTPConfig
+    websocket   *WebSocketConfig
+    messenger   *messenger
+    grpc        *GRPCConfig
+    caller      *grpcCaller
+    balancer    *balancer
+    // sharedTransport is the transport of the blasters,
+    // nil if http.DefaultTransport is used
+    sharedTransport *pool
+    signer      Signer
+    secrets     []string
     requestBody []byte
+    body        Body
     valid bool
 }
 
@@ -55,26 +98,41 @@ func NewConfiguration(
     durationSeconds int,
     header http.Header) (config *Configuration, err error) {
     config = &Configuration{}
-    if err = config.SetURL(url); err != nil {
-        return
-    }
-    if err = config.SetMethod(method); err != nil {
-        return
-    }
-    if err = config.SetRate(rate); err != nil {
-        return
-    }
-    if err = config.SetDuration(durationSeconds); err != nil {
+
+    // Validate all values, so that every error is returned at once
+    errs := &ValidationError{}
+    errs.add("url", config.SetURL(url))
+    errs.add("method", config.SetMethod(method))
+    errs.add("rate", config.SetRate(rate))
+    errs.add("duration", config.SetDuration(durationSeconds))
+    if err = errs.err(); err != nil {
         return
     }
+
     if header == nil {
         header = http.Header{}
     }
     config.Header = header
-    config.valid = true
+    config.setDefaults()
     return
 }
 
+// setDefaults sets the name of a configuration with
+// valid values and marks it as valid.
+func (c *Configuration) setDefaults() {
+    c.Name = c.HTTPMethod + " " + c.URL.Path
+    if c.URL.Scheme == "ws" || c.URL.Scheme == "wss" {
+        c.Name = "WS " + c.URL.Path
+    }
+    if c.URL.Path == "" {
+        c.Name += "/"
+    }
+    if c.caller != nil {
+        c.Name = c.caller.name()
+    }
+    c.valid = true
+}
+
 // SetRate sets the request rate of each blaster.
 func (c *Configuration) SetRate(rate int) error {
     if rate == 0 {
@@ -95,7 +153,7 @@ func (c *Configuration) SetDuration(duration int) error {
         duration = DefaultDuration
     }
 
-    if duration < MinDuration || c.Duration > MaxDuration*time.Second {
+    if duration < MinDuration || duration > MaxDuration {
         return fmt.Errorf("duration must be a positive integer in the range %d-%d", MinDuration, MaxDuration)
     }
 
@@ -103,12 +161,161 @@ func (c *Configuration) SetDuration(duration int) error {
     return nil
 }
 
-// SetURL is useful when testing
+// SetURL sets the target URL. A target listening on a Unix domain
+// socket is given as unix:///path/to.sock:/path, where the HTTP path
+// defaults to /, and all connections are then made to the socket.
 func (c *Configuration) SetURL(u string) (err error) {
-    c.URL, err = url.ParseRequestURI(u)
+    socket := ""
+    if strings.HasPrefix(u, unixScheme) {
+        if socket, u, err = splitSocketURL(u); err != nil {
+            return
+        }
+    }
+
+    if c.URL, err = url.ParseRequestURI(u); err != nil {
+        return
+    }
+    c.socket = socket
+    c.targets, c.balancer = nil, nil
+    c.sharedTransport = c.newTransport()
     return
 }
 
+// SetTargets distributes the requests over several targets, replacing
+// the URL set by SetURL with the first target. The returned error
+// is a *ValidationError.
+func (c *Configuration) SetTargets(targets TargetsConfig) error {
+    balancer, err := targets.validate()
+    if err != nil {
+        return err
+    }
+
+    c.URL = balancer.targets[0]
+    c.socket = ""
+    c.sharedTransport = c.newTransport()
+    c.targets, c.balancer = &targets, balancer
+    return nil
+}
+
+// Targets returns the targets set by SetTargets, or nil.
+func (c *Configuration) Targets() *TargetsConfig {
+    return c.targets
+}
+
+// SetProxy makes the requests connect through the proxy configured
+// by proxy, rather than any proxy of the HTTP_PROXY and HTTPS_PROXY
+// environment variables. The returned error is a *ValidationError.
+func (c *Configuration) SetProxy(proxy ProxyConfig) error {
+    if err := proxy.validate(); err != nil {
+        return err
+    }
+
+    c.proxy = &proxy
+    c.AddSecret(proxy.secrets()...)
+    c.sharedTransport = c.newTransport()
+    return nil
+}
+
+// Proxy returns the proxy set by SetProxy, or nil.
+func (c *Configuration) Proxy() *ProxyConfig {
+    return c.proxy
+}
+
+// SetHTTP sets the HTTP protocol of the requests and the connections
+// they are sent over. The returned error is a *ValidationError.
+func (c *Configuration) SetHTTP(config HTTPConfig) error {
+    if err := config.validate(); err != nil {
+        return err
+    }
+
+    c.http = &config
+    c.sharedTransport = c.newTransport()
+    return nil
+}
+
+// HTTP returns the configuration set by SetHTTP, or nil.
+func (c *Configuration) HTTP() *HTTPConfig {
+    return c.http
+}
+
+// SetWebSocket makes the blasters send WebSocket messages as
+// configured by ws, rather than HTTP requests. The returned
+// error is a *ValidationError.
+func (c *Configuration) SetWebSocket(ws WebSocketConfig) error {
+    m, err := ws.validate()
+    if err != nil {
+        return err
+    }
+
+    c.websocket, c.messenger = &ws, m
+    return nil
+}
+
+// WebSocket returns the configuration set by SetWebSocket, or nil.
+func (c *Configuration) WebSocket() *WebSocketConfig {
+    return c.websocket
+}
+
+// SetGRPC makes the blasters call the gRPC method configured by
+// g, rather than sending HTTP requests, and names the calls like
+// GRPC /package.Service/Method. The calls use HTTP/2, over TLS
+// for https URLs and in cleartext otherwise. The returned error
+// is a *ValidationError.
+func (c *Configuration) SetGRPC(g GRPCConfig) error {
+    caller, err := g.validate()
+    if err != nil {
+        return err
+    }
+
+    c.grpc, c.caller = &g, caller
+    c.Name = caller.name()
+    return nil
+}
+
+// GRPC returns the configuration set by SetGRPC, or nil.
+func (c *Configuration) GRPC() *GRPCConfig {
+    return c.grpc
+}
+
+// Socket returns the path of the Unix domain socket
+// of the target, or an empty string.
+func (c *Configuration) Socket() string {
+    return c.socket
+}
+
+// Target returns the target URL as given to SetURL.
+func (c *Configuration) Target() string {
+    if c.socket != "" {
+        return unixScheme + c.socket + ":" + c.URL.RequestURI()
+    }
+    return c.URL.String()
+}
+
+// Resolve makes the connections to hostport, e.g. example.com:443,
+// be made to address instead, like curl's --resolve and --connect-to,
+// see ParseResolve and ParseConnectTo. The Host header and the
+// TLS server name are still those of the URL.
+func (c *Configuration) Resolve(hostport, address string) error {
+    for _, a := range []string{hostport, address} {
+        if _, _, err := net.SplitHostPort(a); err != nil {
+            return err
+        }
+    }
+
+    if c.resolve == nil {
+        c.resolve = map[string]string{}
+    }
+    c.resolve[strings.ToLower(hostport)] = address
+    c.sharedTransport = c.newTransport()
+    return nil
+}
+
+// Resolved returns the addresses set by Resolve,
+// by the host:port they are used for.
+func (c *Configuration) Resolved() map[string]string {
+    return c.resolve
+}
+
 func (c *Configuration) SetMethod(method string) error {
     if method == "" {
         log.Printf("Using default HTTP methid: %s", http.MethodGet)
@@ -136,39 +343,336 @@ func (c *Configuration) Valid() bool {
 // SetRequestBody sets the request body of this configuration.
 func (c *Configuration) SetRequestBody(body []byte) {
     c.requestBody = body
+    c.body = nil
+}
+
+// SetBody sets a body that is opened for each request, replacing
+// any body set by SetRequestBody. Its content type replaces
+// the Content-Type header of the configuration.
+func (c *Configuration) SetBody(body Body) {
+    c.body = body
+    c.requestBody = nil
 }
 
 // BuildRequest returns the corresponding request object that
 // that this configuration describes.
-func (c *Configuration) BuildRequest() ( req*http.Request, err error) {
+func (c *Configuration) BuildRequest() (*http.Request, error) {
+    return c.BuildRequestFor(RequestInfo{Name: c.Name})
+}
+
+// BuildRequestFor returns the request described by this
+// configuration, with the body opened for the given request.
+func (c *Configuration) BuildRequestFor(info RequestInfo) (req *http.Request, err error) {
     if !c.valid {
         err = fmt.Errorf("invalid configuration, use NewConfiguration to create")
         return
     }
 
+    target := c.URL
+    if c.balancer != nil {
+        if target, err = c.balancer.pick(info); err != nil {
+            return
+        }
+    }
+
+    if c.caller != nil {
+        if req, err = c.caller.request(target, info); err == nil {
+            header := c.Header.Clone()
+            for k, v := range req.Header {
+                header[k] = v
+            }
+            req.Header = header
+        }
+        return
+    }
+
     var body io.Reader
-    if c.requestBody != nil {
+    var contentType string
+    length := int64(-1)
+    if c.body != nil {
+        var rc io.ReadCloser
+        rc, contentType, length, err = c.body.Open(info)
+        if err != nil {
+            return
+        }
+        body = rc
+    } else if c.requestBody != nil {
         body = bytes.NewReader(c.requestBody)
     }
 
-    req, err = http.NewRequest(c.HTTPMethod, c.URL.String(), body)
-    if req != nil {
-        req.Header = c.Header
+    req, err = http.NewRequest(c.HTTPMethod, target.String(), body)
+    if err != nil {
+        if closer, ok := body.(io.Closer); ok {
+            closer.Close()
+        }
+        return
+    }
+
+    // Each request gets its own copy so that it can be modified
+    req.Header = c.Header.Clone()
+    if c.body != nil {
+        req.ContentLength = length
+        req.Header.Set("Content-Type", contentType)
     }
 
     return
 }
 
+// Use registers hooks that are called around each request,
+// in the order they are registered.
+func (c *Configuration) Use(hooks ...Hook) {
+    c.hooks = append(c.hooks, hooks...)
+}
+
+// SetAuth makes the requests authenticated as configured by auth,
+// see NewAuth. The returned error is a *ValidationError.
+func (c *Configuration) SetAuth(auth AuthConfig) error {
+    if err := auth.validate(); err != nil {
+        return err
+    }
+
+    c.auth = &auth
+    c.AddSecret(auth.secrets()...)
+    c.Use(newAuth(auth))
+    return nil
+}
+
+// Auth returns the authentication set by SetAuth, or nil.
+func (c *Configuration) Auth() *AuthConfig {
+    return c.auth
+}
+
+// SetJWT makes each request carry a JWT minted as configured by
+// jwt, see NewJWT. The returned error is a *ValidationError.
+func (c *Configuration) SetJWT(jwt JWTConfig) error {
+    minter, err := jwt.validate()
+    if err != nil {
+        return err
+    }
+
+    c.jwt = &jwt
+    c.AddSecret(jwt.secrets()...)
+    c.Use(minter)
+    return nil
+}
+
+// JWT returns the JWT configuration set by SetJWT, or nil.
+func (c *Configuration) JWT() *JWTConfig {
+    return c.jwt
+}
+
+// SetSigning makes the requests signed as configured by signing,
+// see NewSigner. The returned error is a *ValidationError.
+func (c *Configuration) SetSigning(signing SigningConfig) error {
+    if err := signing.validate(); err != nil {
+        return err
+    }
+
+    c.signing = &signing
+    c.signer = newSigner(signing)
+    c.AddSecret(signing.secrets()...)
+    return nil
+}
+
+// Signing returns the signing set by SetSigning, or nil.
+func (c *Configuration) Signing() *SigningConfig {
+    return c.signing
+}
+
+// requestHooks returns the hooks called around each request,
+// ending with the signing, which must be done last.
+func (c *Configuration) requestHooks() []Hook {
+    if c.signer == nil {
+        return c.hooks
+    }
+    return append(c.hooks[:len(c.hooks):len(c.hooks)], signingHook{signer: c.signer})
+}
+
+// SetSession makes each blaster keep a session as configured by
+// session. The returned error is a *ValidationError.
+func (c *Configuration) SetSession(session SessionConfig) error {
+    if err := session.validate(); err != nil {
+        return err
+    }
+
+    c.session = &session
+    return nil
+}
+
+// Session returns the session set by SetSession, or nil.
+func (c *Configuration) Session() *SessionConfig {
+    return c.session
+}
+
 // UpdateHeader add all entries that do not exist in the configuration
 // and override any existing values.
 func (c *Configuration) UpdateHeader(header http.Header) {
     for key := range header {
         value := header.Get(key)
         if _, exists := c.Header[key]; exists {
-            log.Printf("Overwriting header with new value: %s = %s", key, value)
+            log.Printf("Overwriting header with new value: %s = %s", key, c.Mask(value))
         } else {
-            log.Printf("Adding header: %s = %s", key, value)
+            log.Printf("Adding header: %s = %s", key, c.Mask(value))
         }
         c.Header.Set(key, value)
     }
 }
+
+// AddSecret adds values, e.g. tokens, that are masked
+// when the configuration is printed or logged.
+func (c *Configuration) AddSecret(values ...string) {
+    for _, v := range values {
+        if v != "" {
+            c.secrets = append(c.secrets, v)
+        }
+    }
+}
+
+// Mask returns s with each secret value replaced by ****. Values
+// shorter than minSecretLength are not masked, since they would mask
+// unrelated parts of s.
+func (c *Configuration) Mask(s string) string {
+    for _, secret := range c.secrets {
+        if len(secret) < minSecretLength {
+            continue
+        }
+        s = strings.Replace(s, secret, "****", -1)
+    }
+    return s
+}
+
+// configJSON is the JSON representation of a Configuration,
+// used when sending a configuration to another process.
+type configJSON struct {
+    Name      string            `json:"name"`
+    URL       string            `json:"url"`
+    Method    string            `json:"method"`
+    Rate      int               `json:"rate"`
+    Duration  time.Duration     `json:"duration"`
+    Header    http.Header       `json:"header"`
+    Body      []byte            `json:"body,omitempty"`
+    Auth      *AuthConfig       `json:"auth,omitempty"`
+    Signing   *SigningConfig    `json:"signing,omitempty"`
+    JWT       *JWTConfig        `json:"jwt,omitempty"`
+    Session   *SessionConfig    `json:"session,omitempty"`
+    Resolve   map[string]string `json:"resolve,omitempty"`
+    Targets   *TargetsConfig    `json:"targets,omitempty"`
+    Proxy     *ProxyConfig      `json:"proxy,omitempty"`
+    HTTP      *HTTPConfig       `json:"http,omitempty"`
+    WebSocket *WebSocketConfig  `json:"websocket,omitempty"`
+    GRPC      *GRPCConfig       `json:"grpc,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. A configuration with
+// a body set by SetBody or a Scenario can't be marshaled, nor can
+// one holding secrets unless SendSecrets is set. The Metrics are
+// left out, they are pushed by the sending process.
+func (c *Configuration) MarshalJSON() ([]byte, error) {
+    if c.body != nil {
+        return nil, fmt.Errorf("a body opened for each request, e.g. multipart, can't be sent to another process")
+    }
+    if c.Scenario != nil {
+        return nil, fmt.Errorf("a scenario can't be sent to another process")
+    }
+    if len(c.secrets) > 0 && !c.SendSecrets {
+        return nil, fmt.Errorf("the configuration holds secrets, e.g. credentials, which are only sent to another process if SendSecrets is set")
+    }
+
+    return json.Marshal(configJSON{
+        Name:      c.Name,
+        URL:       c.Target(),
+        Method:    c.HTTPMethod,
+        Rate:      c.Rate,
+        Duration:  c.Duration,
+        Header:    c.Header,
+        Body:      c.requestBody,
+        Auth:      c.auth,
+        Signing:   c.signing,
+        JWT:       c.jwt,
+        Session:   c.session,
+        Resolve:   c.resolve,
+        Targets:   c.targets,
+        Proxy:     c.proxy,
+        HTTP:      c.http,
+        WebSocket: c.websocket,
+        GRPC:      c.grpc,
+    })
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The resulting
+// configuration is validated as if created by NewConfiguration,
+// with a duration in the range MinDuration-MaxDuration seconds.
+func (c *Configuration) UnmarshalJSON(b []byte) error {
+    var j configJSON
+    if err := json.Unmarshal(b, &j); err != nil {
+        return err
+    }
+
+    if j.Duration < MinDuration*time.Second || j.Duration > MaxDuration*time.Second {
+        return fmt.Errorf("duration must be in the range %d-%d seconds", MinDuration, MaxDuration)
+    }
+
+    config, err := NewConfiguration(j.URL, j.Method, j.Rate, MinDuration, j.Header)
+    if err != nil {
+        return err
+    }
+
+    config.Duration = j.Duration
+    config.requestBody = j.Body
+    if j.Auth != nil {
+        if err := config.SetAuth(*j.Auth); err != nil {
+            return err
+        }
+    }
+    if j.JWT != nil {
+        if err := config.SetJWT(*j.JWT); err != nil {
+            return err
+        }
+    }
+    if j.Signing != nil {
+        if err := config.SetSigning(*j.Signing); err != nil {
+            return err
+        }
+    }
+    if j.Targets != nil {
+        if err := config.SetTargets(*j.Targets); err != nil {
+            return err
+        }
+    }
+    if j.Proxy != nil {
+        if err := config.SetProxy(*j.Proxy); err != nil {
+            return err
+        }
+    }
+    if j.HTTP != nil {
+        if err := config.SetHTTP(*j.HTTP); err != nil {
+            return err
+        }
+    }
+    if j.WebSocket != nil {
+        if err := config.SetWebSocket(*j.WebSocket); err != nil {
+            return err
+        }
+    }
+    if j.GRPC != nil {
+        if err := config.SetGRPC(*j.GRPC); err != nil {
+            return err
+        }
+    }
+    for from, to := range j.Resolve {
+        if err := config.Resolve(from, to); err != nil {
+            return err
+        }
+    }
+    if j.Session != nil {
+        if err := config.SetSession(*j.Session); err != nil {
+            return err
+        }
+    }
+    if j.Name != "" {
+        config.Name = j.Name
+    }
+
+    *c = *config
+    return nil
+}
@@ -0,0 +1,129 @@
+package blaster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// unixScheme is the scheme of a target listening on a Unix domain
+// socket, e.g. unix:///var/run/app.sock:/health, see SetURL.
+const unixScheme = "unix://"
+
+// splitSocketURL splits a URL of a Unix domain socket into the
+// path of the socket and the HTTP URL of the requests.
+func splitSocketURL(u string) (socket, httpURL string, err error) {
+	socket, path := strings.TrimPrefix(u, unixScheme), "/"
+	if i := strings.Index(socket, ":"); i >= 0 {
+		socket, path = socket[:i], socket[i+1:]
+	}
+	if socket == "" {
+		return "", "", fmt.Errorf("the socket is missing, use %s/path/to.sock:/path", unixScheme)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("the HTTP path of %s must start with /", u)
+	}
+	return socket, "http://localhost" + path, nil
+}
+
+// ParseResolve parses a rule like curl's --resolve, host:port:address,
+// into the host:port to connect to address instead, see
+// Configuration.Resolve. The port is the same.
+func ParseResolve(rule string) (hostport, address string, err error) {
+	parts := strings.SplitN(rule, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("invalid resolve %s, use host:port:address", rule)
+	}
+
+	address = strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+	return net.JoinHostPort(parts[0], parts[1]), net.JoinHostPort(address, parts[1]), nil
+}
+
+// ParseConnectTo parses a rule like curl's --connect-to,
+// host:port:connect-host:connect-port, into the host:port
+// to connect to connect-host:connect-port instead, see
+// Configuration.Resolve. An empty connect-host or
+// connect-port keeps the host or port.
+func ParseConnectTo(rule string) (hostport, address string, err error) {
+	invalid := fmt.Errorf("invalid connect-to %s, use host:port:connect-host:connect-port", rule)
+	i := strings.LastIndex(rule, ":")
+	if i < 0 {
+		return "", "", invalid
+	}
+
+	parts := strings.SplitN(rule[:i], ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", invalid
+	}
+
+	host, port := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]"), rule[i+1:]
+	if host == "" {
+		host = parts[0]
+	}
+	if port == "" {
+		port = parts[1]
+	}
+	return net.JoinHostPort(parts[0], parts[1]), net.JoinHostPort(host, port), nil
+}
+
+// dialer makes the connections of the requests, to the Unix domain
+// socket if set, otherwise to the address that the host:port of
+// each connection resolves to, through the SOCKS5 proxy if set.
+type dialer struct {
+	net.Dialer
+	socket  string
+	resolve map[string]string
+	socks   *socks5
+}
+
+// DialContext dials the address of the connection to addr.
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.socket != "" {
+		return d.Dialer.DialContext(ctx, "unix", d.socket)
+	}
+	if to, ok := d.resolve[strings.ToLower(addr)]; ok {
+		addr = to
+	}
+	if d.socks != nil {
+		return d.socks.dial(ctx, &d.Dialer, addr)
+	}
+	return d.Dialer.DialContext(ctx, network, addr)
+}
+
+// newTransport returns the transport of the requests configured by c, or
+// nil if http.DefaultTransport can be used. The Host header and the TLS
+// server name are those of the URL also when the connection is made
+// to another address.
+func (c *Configuration) newTransport() *pool {
+	if c.socket == "" && len(c.resolve) == 0 && c.proxy == nil && c.http == nil {
+		return nil
+	}
+
+	d := &dialer{
+		Dialer:  net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		socket:  c.socket,
+		resolve: map[string]string{},
+	}
+	for from, to := range c.resolve {
+		d.resolve[from] = to
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = d.DialContext
+	// The connections to a Unix domain socket are never proxied
+	if c.proxy != nil && c.socket == "" {
+		c.proxy.configure(t, d)
+	}
+	return newPool(t, c.http)
+}
+
+// transport returns the transport shared by the blasters.
+func (c *Configuration) transport() *pool {
+	if c.sharedTransport != nil {
+		return c.sharedTransport
+	}
+	return defaultPool
+}
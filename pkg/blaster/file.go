@@ -1,64 +1,659 @@
 package blaster
 
 import (
+    "encoding/base64"
     "encoding/json"
-    "gopkg.in/yaml.v2"
+    "fmt"
     "io/ioutil"
     "log"
+    "mime"
     "net/http"
+    "net/url"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v3"
 )
 
-// blastFile is the structure of a BlastFile, i.e. a YAML file
-// that contains the configuration for a blast.
+// blastFile is the structure of a BlastFile, i.e. a YAML, JSON
+// or TOML file that contains the configuration for a blast.
 type blastFile struct {
     Rate     int `yaml:"rate"`
     Duration int `yaml:"duration"`
+    Script   string `yaml:"script"`
     Request  struct {
-        URL     string `yaml:"url"`
+        Name    string `yaml:"name"`
+        // URL is one URL, or several to distribute the requests over
+        URL       stringList `yaml:"url"`
+        HostsFile string     `yaml:"hosts_file"`
+        Strategy  string     `yaml:"strategy"`
+        HashKey   string     `yaml:"hash_key"`
         Method  string `yaml:"method"`
+        // Resolve and ConnectTo are like curl's --resolve and --connect-to
+        Resolve   []string `yaml:"resolve"`
+        ConnectTo []string `yaml:"connect_to"`
         Headers []struct {
-            Name  string `yaml:"name"`
-            Value string `yaml:"value"`
+            Name          string `yaml:"name"`
+            Value         string `yaml:"value"`
+            ValueFromFile string `yaml:"value_from_file"`
         } `yaml:"headers"`
-        Body map[string]interface{} `yaml:"body"`
-    }
+        // Only one of the body fields may be given
+        Body       interface{}            `yaml:"body"`
+        BodyRaw    string                 `yaml:"body_raw"`
+        BodyFile   string                 `yaml:"body_file"`
+        BodyBase64 string                 `yaml:"body_base64"`
+        BodyForm   map[string]interface{} `yaml:"body_form"`
+        Multipart  *struct {
+            Fields []struct {
+                Name  string `yaml:"name"`
+                Value string `yaml:"value"`
+            } `yaml:"fields"`
+            Files []struct {
+                Name        string `yaml:"name"`
+                Path        string `yaml:"path"`
+                Filename    string `yaml:"filename"`
+                ContentType string `yaml:"content_type"`
+            } `yaml:"files"`
+        } `yaml:"multipart"`
+    } `yaml:"request"`
+    Auth *struct {
+        Type         string   `yaml:"type"`
+        Username     string   `yaml:"username"`
+        Password     string   `yaml:"password"`
+        Token        string   `yaml:"token"`
+        TokenURL     string   `yaml:"token_url"`
+        ClientID     string   `yaml:"client_id"`
+        ClientSecret string   `yaml:"client_secret"`
+        Scopes       []string `yaml:"scopes"`
+        Grant        string   `yaml:"grant"`
+    } `yaml:"auth"`
+    JWT *struct {
+        Algorithm string                 `yaml:"algorithm"`
+        Key       string                 `yaml:"key"`
+        KeyFile   string                 `yaml:"key_file"`
+        KeyID     string                 `yaml:"kid"`
+        Claims    map[string]interface{} `yaml:"claims"`
+        ExpiresIn int                    `yaml:"expires_in"`
+        Header    string                 `yaml:"header"`
+        Prefix    string                 `yaml:"prefix"`
+        Mint      string                 `yaml:"mint"`
+        Data      string                 `yaml:"data"`
+    } `yaml:"jwt"`
+    Signing *struct {
+        Type            string   `yaml:"type"`
+        Service         string   `yaml:"service"`
+        Region          string   `yaml:"region"`
+        AccessKeyID     string   `yaml:"access_key_id"`
+        SecretAccessKey string   `yaml:"secret_access_key"`
+        SessionToken    string   `yaml:"session_token"`
+        Secret          string   `yaml:"secret"`
+        Algorithm       string   `yaml:"algorithm"`
+        Header          string   `yaml:"header"`
+        Headers         []string `yaml:"headers"`
+        Body            bool     `yaml:"body"`
+        TimestampHeader string   `yaml:"timestamp_header"`
+        Encoding        string   `yaml:"encoding"`
+        Prefix          string   `yaml:"prefix"`
+    } `yaml:"signing"`
+    Proxy *struct {
+        URL      string `yaml:"url"`
+        Username string `yaml:"username"`
+        Password string `yaml:"password"`
+    } `yaml:"proxy"`
+    HTTP *struct {
+        Protocol    string `yaml:"protocol"`
+        Connections int    `yaml:"connections"`
+        MaxStreams  int    `yaml:"max_streams"`
+    } `yaml:"http"`
+    WebSocket *struct {
+        Connections  int      `yaml:"connections"`
+        Messages     []string `yaml:"messages"`
+        MessagesFile string   `yaml:"messages_file"`
+        Binary       bool     `yaml:"binary"`
+        Expect       string   `yaml:"expect"`
+        Timeout      int      `yaml:"timeout"`
+        Data         string   `yaml:"data"`
+    } `yaml:"websocket"`
+    GRPC *struct {
+        Method      string                 `yaml:"method"`
+        ProtoFiles  []string               `yaml:"proto_files"`
+        ImportPaths []string               `yaml:"import_paths"`
+        Message     map[string]interface{} `yaml:"message"`
+        Data        string                 `yaml:"data"`
+    } `yaml:"grpc"`
+    Session *struct {
+        Cookies     bool `yaml:"cookies"`
+        Connections bool `yaml:"connections"`
+        ResetEvery  int  `yaml:"reset_every"`
+    } `yaml:"session"`
+    Metrics struct {
+        Interval int    `yaml:"interval"`
+        Prefix   string `yaml:"prefix"`
+        Sinks    []struct {
+            Type    string `yaml:"type"`
+            Address string `yaml:"address"`
+            URL     string `yaml:"url"`
+        } `yaml:"sinks"`
+    } `yaml:"metrics"`
 }
 
 // LoadFile returns the resulting configuration in the file.
 // filepath must be a path to a YAML file conforming to the
 // structure of a blast configuration file.
+//
+// Unknown fields are rejected. If the file is invalid the returned
+// error is a *ValidationError with the position of each error.
 func LoadFile(filename string) (*Configuration, error) {
+    return LoadProfile(filename, "")
+}
+
+// LoadProfile is like LoadFile but returns the configuration of the
+// given profile in the file. An empty profile gives the defaults
+// of the file, i.e. ignoring the profiles.
+func LoadProfile(filename, profile string) (*Configuration, error) {
     b, err := ioutil.ReadFile(filename)
     if err != nil {
         return nil, err
     }
+    return ParseProfile(filename, b, profile)
+}
 
-    c := &blastFile{}
-    err = yaml.Unmarshal(b, c)
-    if err != nil {
+// ParseFile is like LoadFile but reads the file from b.
+// The filename is used in errors and to resolve relative paths.
+func ParseFile(filename string, b []byte) (*Configuration, error) {
+    return ParseProfile(filename, b, "")
+}
+
+// ParseProfile is like LoadProfile but reads the file from b.
+func ParseProfile(filename string, b []byte, profile string) (*Configuration, error) {
+    v := newFileValidator(filename)
+
+    // Combine the files and profiles into a single blast
+    doc := v.load(filename, b, nil)
+    if doc != nil && v.err() == nil {
+        doc = v.profile(doc, profile)
+    }
+    if err := v.err(); err != nil {
         return nil, err
     }
 
+    v.interpolate(doc, "")
+
+    // Check the structure first, so that no field is silently ignored.
+    // Decoding then only fails for fields that already have an error,
+    // and the rest of the fields are still validated below.
+    v.check(doc, reflect.TypeOf(blastFile{}), "")
+    c := &blastFile{}
+    if err := doc.Decode(c); err != nil && v.err() == nil {
+        v.syntaxError(filename, err)
+        return nil, v.err()
+    }
+
     header := http.Header{}
-    for _, h := range c.Request.Headers {
-        log.Printf("%s: %s", h.Name, h.Value)
-        header.Add(h.Name, h.Value)
+    for i, h := range c.Request.Headers {
+        field := fmt.Sprintf("request.headers[%d]", i)
+        if h.Name == "" {
+            v.add(field+".name", fmt.Errorf("the name of a header is required"))
+            continue
+        }
+
+        value := h.Value
+        if h.ValueFromFile != "" {
+            if h.Value != "" {
+                v.add(field+".value_from_file", fmt.Errorf("only one of value and value_from_file may be given"))
+                continue
+            }
+
+            var err error
+            value, err = readSecret(v.relative(field+".value_from_file", h.ValueFromFile))
+            v.add(field+".value_from_file", err)
+            v.secrets = append(v.secrets, value)
+        }
+        header.Add(h.Name, value)
     }
 
-    config, err :=  NewConfiguration(
-        c.Request.URL,
-        c.Request.Method,
-        c.Rate,
-        c.Duration,
-        header)
-    if err != nil {
+    body, contentType := v.body(c)
+    if body != nil && header.Get("Content-Type") == "" {
+        header.Set("Content-Type", contentType)
+    }
+    multipart := v.multipart(c)
+
+    // Validate each field, rather than using NewConfiguration,
+    // to get the position of each error
+    config := &Configuration{Header: header}
+    v.targets(c, config)
+    v.add("request.method", config.SetMethod(c.Request.Method))
+    for field, rules := range map[string][]string{"resolve": c.Request.Resolve, "connect_to": c.Request.ConnectTo} {
+        parse := ParseResolve
+        if field == "connect_to" {
+            parse = ParseConnectTo
+        }
+        for i, rule := range rules {
+            field := fmt.Sprintf("request.%s[%d]", field, i)
+            hostport, address, err := parse(rule)
+            if err == nil {
+                err = config.Resolve(hostport, address)
+            }
+            v.add(field, err)
+        }
+    }
+    v.add("rate", config.SetRate(c.Rate))
+    v.add("duration", config.SetDuration(c.Duration))
+    if c.Metrics.Interval < 0 {
+        v.add("metrics.interval", fmt.Errorf("metrics.interval must not be negative"))
+    }
+    if a := c.Auth; a != nil {
+        err := config.SetAuth(AuthConfig{
+            Type:         a.Type,
+            Username:     a.Username,
+            Password:     a.Password,
+            Token:        a.Token,
+            TokenURL:     a.TokenURL,
+            ClientID:     a.ClientID,
+            ClientSecret: a.ClientSecret,
+            Scopes:       a.Scopes,
+            Grant:        a.Grant,
+        })
+        if errs, ok := err.(*ValidationError); ok {
+            for _, e := range errs.Errors {
+                v.add("auth."+e.Field, e.Err)
+            }
+        }
+    }
+    if j := c.JWT; j != nil {
+        jwt := JWTConfig{
+            Algorithm: j.Algorithm,
+            Key:       j.Key,
+            KeyID:     j.KeyID,
+            Claims:    j.Claims,
+            ExpiresIn: time.Duration(j.ExpiresIn) * time.Second,
+            Header:    j.Header,
+            Prefix:    j.Prefix,
+            Mint:      j.Mint,
+        }
+        // The files are relative to the blast file
+        if j.KeyFile != "" {
+            jwt.KeyFile = v.relative("jwt.key_file", j.KeyFile)
+        }
+        if j.Data != "" {
+            jwt.DataFile = v.relative("jwt.data", j.Data)
+        }
+
+        if errs, ok := config.SetJWT(jwt).(*ValidationError); ok {
+            for _, e := range errs.Errors {
+                v.add("jwt."+e.Field, e.Err)
+            }
+        }
+    }
+    if s := c.Signing; s != nil {
+        err := config.SetSigning(SigningConfig{
+            Type:            s.Type,
+            Service:         s.Service,
+            Region:          s.Region,
+            AccessKeyID:     s.AccessKeyID,
+            SecretAccessKey: s.SecretAccessKey,
+            SessionToken:    s.SessionToken,
+            Secret:          s.Secret,
+            Algorithm:       s.Algorithm,
+            Header:          s.Header,
+            Headers:         s.Headers,
+            Body:            s.Body,
+            TimestampHeader: s.TimestampHeader,
+            Encoding:        s.Encoding,
+            Prefix:          s.Prefix,
+        })
+        if errs, ok := err.(*ValidationError); ok {
+            for _, e := range errs.Errors {
+                v.add("signing."+e.Field, e.Err)
+            }
+        }
+    }
+    if p := c.Proxy; p != nil {
+        if p.URL == "" {
+            v.add("proxy.url", fmt.Errorf("proxy.url is required"))
+        } else if errs, ok := config.SetProxy(ProxyConfig{URL: p.URL, Username: p.Username, Password: p.Password}).(*ValidationError); ok {
+            for _, e := range errs.Errors {
+                v.add("proxy."+e.Field, e.Err)
+            }
+        }
+    }
+    if h := c.HTTP; h != nil {
+        err := config.SetHTTP(HTTPConfig{
+            Protocol:    h.Protocol,
+            Connections: h.Connections,
+            MaxStreams:  h.MaxStreams,
+        })
+        if errs, ok := err.(*ValidationError); ok {
+            for _, e := range errs.Errors {
+                v.add("http."+e.Field, e.Err)
+            }
+        }
+    }
+    if c.WebSocket != nil {
+        v.websocket(c, config)
+    }
+    if c.GRPC != nil {
+        v.grpc(c, config)
+    }
+    if s := c.Session; s != nil {
+        err := config.SetSession(SessionConfig{
+            Cookies:     s.Cookies,
+            Connections: s.Connections,
+            ResetEvery:  s.ResetEvery,
+        })
+        if errs, ok := err.(*ValidationError); ok {
+            for _, e := range errs.Errors {
+                v.add("session."+e.Field, e.Err)
+            }
+        }
+    }
+    if err := v.err(); err != nil {
         return nil, err
     }
 
-    var body []byte
-    if c.Request.Body != nil {
-        body, err = json.Marshal(c.Request.Body)
+    config.setDefaults()
+    config.AddSecret(v.secrets...)
+    for key := range header {
+        log.Printf("%s: %s", key, config.Mask(header.Get(key)))
+    }
+    if c.Request.Name != "" {
+        config.Name = c.Request.Name
+    }
+
+    // The script is relative to the blast file
+    if c.Script != "" {
+        config.Script = v.relative("script", c.Script)
+    }
+    config.Profile = profile
+
+    config.Metrics = MetricsConfig{
+        Interval: time.Duration(c.Metrics.Interval) * time.Second,
+        Prefix:   c.Metrics.Prefix,
     }
+    for _, s := range c.Metrics.Sinks {
+        config.Metrics.Sinks = append(config.Metrics.Sinks, SinkConfig{
+            Type:    s.Type,
+            Address: s.Address,
+            URL:     s.URL,
+        })
+    }
+
     config.SetRequestBody(body)
-    return config, err
+    if multipart != nil {
+        config.SetBody(multipart)
+    }
+    return config, nil
+}
+
+// websocket sets the WebSocket configuration of c in config.
+func (v *fileValidator) websocket(c *blastFile, config *Configuration) {
+    w := c.WebSocket
+    if c.Script != "" {
+        v.add("websocket", fmt.Errorf("only one of script and websocket may be given"))
+        return
+    }
+
+    ws := WebSocketConfig{
+        Connections: w.Connections,
+        Messages:    w.Messages,
+        Binary:      w.Binary,
+        Expect:      w.Expect,
+        Timeout:     time.Duration(w.Timeout) * time.Second,
+    }
+
+    // The files are relative to the blast file, with one
+    // message per line in the file of messages
+    if w.MessagesFile != "" {
+        if len(w.Messages) > 0 {
+            v.add("websocket.messages_file", fmt.Errorf("only one of messages and messages_file may be given"))
+            return
+        }
+        b, err := ioutil.ReadFile(v.relative("websocket.messages_file", w.MessagesFile))
+        if err != nil {
+            v.add("websocket.messages_file", err)
+            return
+        }
+        for _, line := range strings.Split(string(b), "\n") {
+            if line = strings.TrimRight(line, "\r"); line != "" {
+                ws.Messages = append(ws.Messages, line)
+            }
+        }
+    }
+    if w.Data != "" {
+        ws.DataFile = v.relative("websocket.data", w.Data)
+    }
+
+    if errs, ok := config.SetWebSocket(ws).(*ValidationError); ok {
+        for _, e := range errs.Errors {
+            field := "websocket." + e.Field
+            if e.Field == "messages" && w.MessagesFile != "" {
+                field = "websocket.messages_file"
+            }
+            v.add(field, e.Err)
+        }
+    }
+}
+
+// grpc sets the gRPC configuration of c in config.
+func (v *fileValidator) grpc(c *blastFile, config *Configuration) {
+    g := c.GRPC
+    if c.Script != "" || c.WebSocket != nil {
+        v.add("grpc", fmt.Errorf("only one of script, websocket and grpc may be given"))
+        return
+    }
+
+    // The files are relative to the blast file, as are the
+    // imports unless other import paths are given
+    grpc := GRPCConfig{Method: g.Method, Message: g.Message}
+    for i, f := range g.ProtoFiles {
+        grpc.ProtoFiles = append(grpc.ProtoFiles, v.relative(fmt.Sprintf("grpc.proto_files[%d]", i), f))
+    }
+    for i, dir := range g.ImportPaths {
+        grpc.ImportPaths = append(grpc.ImportPaths, v.relative(fmt.Sprintf("grpc.import_paths[%d]", i), dir))
+    }
+    if len(g.ProtoFiles) > 0 && len(g.ImportPaths) == 0 {
+        grpc.ImportPaths = []string{v.relative("grpc.proto_files", ".")}
+    }
+    if g.Data != "" {
+        grpc.DataFile = v.relative("grpc.data", g.Data)
+    }
+
+    if errs, ok := config.SetGRPC(grpc).(*ValidationError); ok {
+        for _, e := range errs.Errors {
+            v.add("grpc."+e.Field, e.Err)
+        }
+    }
+}
+
+// targets sets the URL of the request, or the targets
+// if several are given, in config.
+func (v *fileValidator) targets(c *blastFile, config *Configuration) {
+    r := c.Request
+    urls := []string(r.URL)
+    switch {
+    case len(urls) == 0:
+        v.add("request.url", fmt.Errorf("request.url is required"))
+        return
+    case r.HostsFile != "" && len(urls) > 1:
+        v.add("request.hosts_file", fmt.Errorf("request.hosts_file requires a single request.url"))
+        return
+    case r.HostsFile != "":
+        var err error
+        urls, err = ReadHosts(v.relative("request.hosts_file", r.HostsFile), urls[0])
+        if err != nil {
+            v.add("request.hosts_file", err)
+            return
+        }
+    case len(urls) == 1 && r.Strategy == "" && r.HashKey == "":
+        v.add("request.url", config.SetURL(urls[0]))
+        return
+    }
+
+    err := config.SetTargets(TargetsConfig{URLs: urls, Strategy: r.Strategy, HashKey: r.HashKey})
+    if errs, ok := err.(*ValidationError); ok {
+        for _, e := range errs.Errors {
+            field := "request." + e.Field
+            switch {
+            case !strings.HasPrefix(e.Field, "urls"):
+            case r.HostsFile != "":
+                field = "request.hosts_file"
+            case len(r.URL) == 1:
+                field = "request.url"
+            default:
+                field = "request.url" + strings.TrimPrefix(e.Field, "urls")
+            }
+            v.add(field, e.Err)
+        }
+    }
+}
+
+// stringList is a list of strings that may also
+// be given as a single string, e.g. request.url.
+type stringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+    if node.Kind == yaml.ScalarNode {
+        *l = stringList{node.Value}
+        return nil
+    }
+    return node.Decode((*[]string)(l))
+}
+
+// body returns the request body of the blast file,
+// and its content type.
+func (v *fileValidator) body(c *blastFile) ([]byte, string) {
+    r := c.Request
+    given := []string{}
+    for field, set := range map[string]bool{
+        "body":        r.Body != nil,
+        "body_raw":    r.BodyRaw != "",
+        "body_file":   r.BodyFile != "",
+        "body_base64": r.BodyBase64 != "",
+        "body_form":   r.BodyForm != nil,
+        "multipart":   r.Multipart != nil,
+    } {
+        if set {
+            given = append(given, field)
+        }
+    }
+    if len(given) > 1 {
+        sort.Strings(given)
+        v.add("request."+given[1], fmt.Errorf("only one body may be given, not %s", strings.Join(given, " and ")))
+        return nil, ""
+    }
+
+    switch {
+    case r.BodyRaw != "":
+        return []byte(r.BodyRaw), "text/plain; charset=utf-8"
+    case r.BodyFile != "":
+        // The file may be e.g. a mounted secret
+        b, contentType := v.bodyFile("request.body_file", r.BodyFile)
+        v.secrets = append(v.secrets, string(b))
+        return b, contentType
+    case r.BodyBase64 != "":
+        b, err := base64.StdEncoding.DecodeString(r.BodyBase64)
+        v.add("request.body_base64", err)
+        return b, "application/octet-stream"
+    case r.BodyForm != nil:
+        return v.form(r.BodyForm), "application/x-www-form-urlencoded"
+    case r.Body != nil:
+        b, err := json.Marshal(r.Body)
+        v.add("request.body", err)
+        return b, "application/json"
+    }
+    return nil, ""
+}
+
+// multipart returns the multipart body of the blast file, if any.
+// The paths of the files are relative to the blast file.
+func (v *fileValidator) multipart(c *blastFile) *MultipartBody {
+    m := c.Request.Multipart
+    if m == nil {
+        return nil
+    }
+
+    fields := []MultipartField{}
+    for _, f := range m.Fields {
+        fields = append(fields, MultipartField{Name: f.Name, Value: f.Value})
+    }
+    files := []MultipartFile{}
+    for i, f := range m.Files {
+        files = append(files, MultipartFile{
+            Name:        f.Name,
+            Path:        v.relative(fmt.Sprintf("request.multipart.files[%d].path", i), f.Path),
+            Filename:    f.Filename,
+            ContentType: f.ContentType,
+        })
+    }
+
+    body, err := NewMultipartBody(fields, files)
+    if errs, ok := err.(*ValidationError); ok {
+        for _, e := range errs.Errors {
+            v.add("request.multipart."+e.Field, e.Err)
+        }
+    }
+    return body
+}
+
+// bodyFile reads the body from the file at path, given in field.
+// The content type is given by the extension of the file.
+func (v *fileValidator) bodyFile(field, path string) ([]byte, string) {
+    path = v.relative(field, path)
+    b, err := ioutil.ReadFile(path)
+    v.add(field, err)
+
+    contentType := mime.TypeByExtension(filepath.Ext(path))
+    if contentType == "" {
+        contentType = "application/octet-stream"
+    }
+    return b, contentType
+}
+
+// form encodes the fields of a form, where each value
+// is either a single value or a list of values.
+func (v *fileValidator) form(fields map[string]interface{}) []byte {
+    form := url.Values{}
+    for name, value := range fields {
+        field := "request.body_form." + name
+        switch value := value.(type) {
+        case []interface{}:
+            for i, item := range value {
+                if isFormValue(item) {
+                    form.Add(name, fmt.Sprint(item))
+                } else {
+                    v.add(fmt.Sprintf("%s[%d]", field, i), fmt.Errorf("%s must be a string, number or boolean", field))
+                }
+            }
+        default:
+            if isFormValue(value) {
+                form.Add(name, fmt.Sprint(value))
+            } else {
+                v.add(field, fmt.Errorf("%s must be a string, number, boolean or a list of those", field))
+            }
+        }
+    }
+    return []byte(form.Encode())
+}
+
+func isFormValue(value interface{}) bool {
+    switch value.(type) {
+    case string, int, int64, float64, bool:
+        return true
+    default:
+        return false
+    }
+}
+
+// readSecret returns the content of the file
+// at path, without any trailing newline.
+func readSecret(path string) (string, error) {
+    b, err := ioutil.ReadFile(path)
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimRight(string(b), "\r\n"), nil
 }
@@ -0,0 +1,158 @@
+package blaster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The formats of blast files, given by the extension of the file.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// FileFormat returns the format of the blast file filename.
+// Files without a known extension are assumed to be YAML.
+func FileFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatYAML
+	}
+}
+
+// parse parses the blast file filename, with the content b, and
+// returns its top level mapping. All formats are parsed into YAML
+// nodes, so that the rest of the validation is the same.
+func (v *fileValidator) parse(filename string, b []byte) *yaml.Node {
+	var doc *yaml.Node
+	switch FileFormat(filename) {
+	case FormatJSON:
+		// YAML accepts more than JSON, e.g. trailing commas
+		var value interface{}
+		if err := json.Unmarshal(b, &value); err != nil {
+			v.jsonError(filename, b, err)
+			return nil
+		}
+		doc = v.parseYAML(filename, b)
+	case FormatTOML:
+		doc = v.parseTOML(filename, b)
+	default:
+		doc = v.parseYAML(filename, b)
+	}
+
+	if doc == nil || !v.expect(doc, yaml.MappingNode, "a mapping", "") {
+		return nil
+	}
+	return doc
+}
+
+func (v *fileValidator) parseYAML(filename string, b []byte) *yaml.Node {
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		v.syntaxError(filename, err)
+		return nil
+	}
+
+	if len(root.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	return root.Content[0]
+}
+
+// jsonError adds the syntax error of a JSON file.
+func (v *fileValidator) jsonError(filename string, b []byte, err error) {
+	e := &FieldError{File: filename, Err: err}
+
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		e.Line, e.Column = position(b, int(syntax.Offset))
+	}
+	v.errs.Errors = append(v.errs.Errors, e)
+}
+
+// position returns the line and column of offset in b.
+func position(b []byte, offset int) (int, int) {
+	before := b[:offset]
+	return bytes.Count(before, []byte("\n")) + 1, len(before) - bytes.LastIndexByte(before, '\n')
+}
+
+// parseTOML parses a TOML file. The TOML parser doesn't keep the
+// position of the values, so only syntax errors have a position.
+func (v *fileValidator) parseTOML(filename string, b []byte) *yaml.Node {
+	var values map[string]interface{}
+	if _, err := toml.Decode(string(b), &values); err != nil {
+		e := &FieldError{File: filename, Err: err}
+
+		var parse toml.ParseError
+		if errors.As(err, &parse) && parse.Position.Start <= len(b) {
+			e.Line, e.Column = position(b, parse.Position.Start)
+			if parse.Message != "" {
+				e.Err = fmt.Errorf("%s", parse.Message)
+			}
+		}
+		v.errs.Errors = append(v.errs.Errors, e)
+		return nil
+	}
+	return tomlNode(values)
+}
+
+// tomlNode converts a value decoded from TOML to a YAML node.
+func tomlNode(value interface{}) *yaml.Node {
+	scalar := func(tag, value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, k := range keys {
+			node.Content = append(node.Content, scalar("!!str", k), tomlNode(value[k]))
+		}
+		return node
+	case []map[string]interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range value {
+			node.Content = append(node.Content, tomlNode(item))
+		}
+		return node
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range value {
+			node.Content = append(node.Content, tomlNode(item))
+		}
+		return node
+	case string:
+		return scalar("!!str", value)
+	case int64:
+		return scalar("!!int", strconv.FormatInt(value, 10))
+	case float64:
+		return scalar("!!float", strconv.FormatFloat(value, 'g', -1, 64))
+	case bool:
+		return scalar("!!bool", strconv.FormatBool(value))
+	case time.Time:
+		return scalar("!!timestamp", value.Format(time.RFC3339Nano))
+	default:
+		return scalar("!!str", fmt.Sprint(value))
+	}
+}
@@ -0,0 +1,383 @@
+package blaster
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// MaxBlasters is the maximum number of blasters in a group.
+const MaxBlasters = 100
+
+// Group is a set of blasters sharing the same configuration
+// that are started and waited for together. The group can be
+// controlled while running, e.g. paused or given a new rate,
+// and each such change is recorded as an annotation.
+type Group struct {
+	config *Configuration
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// blasters holds all blasters ever part of the group,
+	// and live the ones not removed using SetBlasters.
+	blasters []*Blaster
+	live     []*Blaster
+	rate     int
+	paused   bool
+	stopped  bool
+	// active is the number of started blasters not yet done,
+	// and lifetime is closed when the group is done
+	active      int
+	lifetime    chan struct{}
+	annotations []Annotation
+	onResult    func(RequestResult)
+	start       time.Time
+	end         time.Time
+}
+
+// Annotation is a change made to a running group.
+type Annotation struct {
+	Time time.Time `json:"time"`
+	// Elapsed is the time since the group was started.
+	Elapsed time.Duration `json:"elapsed"`
+	Message string        `json:"message"`
+}
+
+// NewGroup creates a group of n blasters using the given configuration.
+func NewGroup(config *Configuration, n int) (*Group, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("number of blasters must be a positive integer")
+	}
+
+	g := &Group{
+		config:   config,
+		rate:     config.Rate,
+		lifetime: make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		if _, err := g.add(config.Duration); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// add creates a new blaster running for duration and adds it
+// to the group. The caller must hold g.mu, or own g exclusively.
+func (g *Group) add(duration time.Duration) (*Blaster, error) {
+	b, err := NewBlaster(fmt.Sprintf("#%d", len(g.blasters)), g.config, &g.wg)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.rate != g.config.Rate {
+		if err := b.SetRate(g.rate); err != nil {
+			return nil, err
+		}
+	}
+	if g.paused {
+		b.Pause()
+	}
+
+	b.duration = duration
+	b.onResult = g.onResult
+	b.onDone = g.blasterDone
+	g.blasters = append(g.blasters, b)
+	g.live = append(g.live, b)
+	return b, nil
+}
+
+// setOnResult sets the function called with the outcome
+// of each request. It must be called before Start.
+func (g *Group) setOnResult(fn func(RequestResult)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onResult = fn
+	for _, b := range g.blasters {
+		b.onResult = fn
+	}
+}
+
+// Start starts all the blasters in the group. A group
+// can only be started once, later calls do nothing.
+func (g *Group) Start() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.start.IsZero() {
+		log.Print("The group is already started")
+		return
+	}
+	g.start = time.Now()
+
+	// The lifetime of the group is part of the wait group, which
+	// makes it safe to add blasters until the group is done.
+	g.active = len(g.live)
+	g.wg.Add(len(g.live) + 1)
+	go func() {
+		select {
+		case <-time.After(g.config.Duration):
+		case <-g.lifetime:
+		}
+		g.wg.Done()
+	}()
+
+	for _, b := range g.live {
+		b.Start()
+	}
+}
+
+// Wait blocks until all blasters in the group are done.
+func (g *Group) Wait() {
+	g.wg.Wait()
+
+	g.mu.Lock()
+	if g.end.IsZero() {
+		g.end = time.Now()
+	}
+	g.mu.Unlock()
+}
+
+// Stop signals all blasters in the group to stop.
+func (g *Group) Stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return
+	}
+
+	g.stopped = true
+	g.done()
+	for _, b := range g.live {
+		b.Stop()
+	}
+	if !g.start.IsZero() {
+		g.annotate("stopped")
+	}
+}
+
+// blasterDone ends the lifetime of the group when
+// all started blasters are done, e.g. failed early.
+func (g *Group) blasterDone() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.active == 0 {
+		g.done()
+	}
+}
+
+// done ends the lifetime of the group, unless
+// it has already ended. The caller must hold g.mu.
+func (g *Group) done() {
+	select {
+	case <-g.lifetime:
+	default:
+		close(g.lifetime)
+	}
+}
+
+// Pause makes all blasters stop sending requests until resumed.
+// The duration of the blast is not extended by the pause.
+func (g *Group) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		return
+	}
+
+	g.paused = true
+	for _, b := range g.live {
+		b.Pause()
+	}
+	g.annotate("paused")
+}
+
+// Resume makes all blasters send requests again after a pause.
+func (g *Group) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return
+	}
+
+	g.paused = false
+	for _, b := range g.live {
+		b.Resume()
+	}
+	g.annotate("resumed")
+}
+
+// Paused returns true if the group is paused.
+func (g *Group) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// Rate returns the current rate of each blaster.
+func (g *Group) Rate() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.rate
+}
+
+// SetRate changes the rate of each blaster in the group.
+func (g *Group) SetRate(rate int) error {
+	if rate < MinRate || rate > MaxRate {
+		return fmt.Errorf("rate must be an integer in the range %d-%d", MinRate, MaxRate)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, b := range g.live {
+		if err := b.SetRate(rate); err != nil {
+			return err
+		}
+	}
+
+	g.annotate(fmt.Sprintf("rate changed from %d to %d", g.rate, rate))
+	g.rate = rate
+	return nil
+}
+
+// NumBlasters returns the current number of blasters.
+func (g *Group) NumBlasters() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.live)
+}
+
+// SetBlasters changes the number of blasters in the group. New
+// blasters run until the end of the blast. Removed blasters are
+// stopped, but their requests are still part of the statistics.
+func (g *Group) SetBlasters(n int) error {
+	if n < 1 || n > MaxBlasters {
+		return fmt.Errorf("number of blasters must be an integer in the range 1-%d", MaxBlasters)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	running := !g.start.IsZero()
+	remaining := g.config.Duration
+	if running {
+		remaining -= time.Since(g.start)
+		select {
+		case <-g.lifetime:
+			return fmt.Errorf("the blast is done")
+		default:
+		}
+		if remaining <= 0 {
+			return fmt.Errorf("the blast is done")
+		}
+	}
+
+	before := len(g.live)
+	for len(g.live) < n {
+		b, err := g.add(remaining)
+		if err != nil {
+			return err
+		}
+		if running {
+			g.active++
+			g.wg.Add(1)
+			b.Start()
+		}
+	}
+	for len(g.live) > n {
+		last := g.live[len(g.live)-1]
+		g.live = g.live[:len(g.live)-1]
+		if running {
+			last.Stop()
+		}
+	}
+
+	g.annotate(fmt.Sprintf("blasters changed from %d to %d", before, n))
+	return nil
+}
+
+// Annotations returns the changes made to the group while running.
+func (g *Group) Annotations() []Annotation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Annotation(nil), g.annotations...)
+}
+
+// annotate records a change. The caller must hold g.mu.
+func (g *Group) annotate(msg string) {
+	now := time.Now()
+	var elapsed time.Duration
+	if !g.start.IsZero() {
+		elapsed = now.Sub(g.start)
+	}
+
+	log.Printf("Blast %s after %v", msg, elapsed)
+	g.annotations = append(g.annotations, Annotation{
+		Time:    now,
+		Elapsed: elapsed,
+		Message: msg,
+	})
+}
+
+// Blasters returns all blasters that have been part of the group.
+func (g *Group) Blasters() []*Blaster {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]*Blaster(nil), g.blasters...)
+}
+
+// Snapshot returns the merged statistics of all blasters.
+func (g *Group) Snapshot() Snapshot {
+	s := NewSnapshot()
+	for _, b := range g.Blasters() {
+		s.Merge(b.Snapshot())
+	}
+	return s
+}
+
+// Elapsed returns the time since the group was started, or the
+// total running time if the group is done.
+func (g *Group) Elapsed() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch {
+	case g.start.IsZero():
+		return 0
+	case g.end.IsZero():
+		return time.Since(g.start)
+	default:
+		return g.end.Sub(g.start)
+	}
+}
+
+// TargetRate returns the total number of requests per
+// second that the group aims to send.
+func (g *Group) TargetRate() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return float64(g.rate * len(g.live))
+}
+
+// AchievedRate returns the average number of requests per
+// second sent since the group was started.
+func (g *Group) AchievedRate() float64 {
+	elapsed := g.Elapsed().Seconds()
+	if elapsed == 0 {
+		return 0
+	}
+	return float64(g.Snapshot().Total().Total) / elapsed
+}
+
+// ActiveBlasters returns the number of blasters currently running.
+func (g *Group) ActiveBlasters() int {
+	n := 0
+	for _, b := range g.Blasters() {
+		if b.Running() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,464 @@
+package blaster
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GRPCConfig makes the blasters call a unary or server streaming
+// gRPC method, over HTTP/2 to the target URL, rather than sending
+// the configured HTTP request. The service is defined by .proto
+// files or, if none are given, fetched using server reflection
+// when the blast is run.
+type GRPCConfig struct {
+	// Method is the full name of the method,
+	// e.g. helloworld.Greeter/SayHello.
+	Method string `json:"method"`
+	// ProtoFiles define the service. Their imports are read
+	// relative to the working directory or ImportPaths.
+	ProtoFiles  []string `json:"proto_files,omitempty"`
+	ImportPaths []string `json:"import_paths,omitempty"`
+	// Message is the input of the calls, as decoded from YAML or
+	// JSON following the protobuf JSON mapping. The strings are
+	// templates, rendered with the RequestInfo of the call and
+	// the data Row, e.g. {{.Row.user}}.
+	Message map[string]interface{} `json:"message,omitempty"`
+	// Rows is data used by the message. Each call uses the next
+	// row, starting over when all have been used. It's read from
+	// DataFile, a CSV file with the names of the columns on the
+	// first line, if not given.
+	Rows     []map[string]string `json:"rows,omitempty"`
+	DataFile string              `json:"-"`
+	// Sources holds the content of the .proto files and their
+	// imports by name, read by SetGRPC unless given.
+	Sources map[string]string `json:"sources,omitempty"`
+}
+
+// String describes c.
+func (c GRPCConfig) String() string {
+	if len(c.ProtoFiles) == 0 {
+		return c.Method + " using server reflection"
+	}
+	return c.Method + " defined by " + strings.Join(c.ProtoFiles, ", ")
+}
+
+// validate reads the files of c and returns the caller, or a
+// *ValidationError with fields such as proto_files.
+func (c *GRPCConfig) validate() (*grpcCaller, error) {
+	errs := &ValidationError{}
+	g := &grpcCaller{config: c}
+
+	service, method, err := splitMethod(c.Method)
+	errs.add("method", err)
+	if len(c.ProtoFiles) > 0 {
+		files, err := c.compile()
+		errs.add("proto_files", err)
+		if err == nil && service != "" {
+			d, _ := files.AsResolver().FindDescriptorByName(protoreflect.FullName(service))
+			if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+				g.method, err = grpcMethod(sd, method)
+			} else {
+				err = fmt.Errorf("unknown service %s", service)
+			}
+			errs.add("method", err)
+		}
+	}
+
+	if c.Rows == nil && c.DataFile != "" {
+		rows, err := readRows(c.DataFile)
+		errs.add("data", err)
+		c.Rows = rows
+	}
+
+	message, err := compileClaims(c.Message, "message")
+	if e, ok := err.(*FieldError); ok {
+		errs.add(e.Field, e.Err)
+	}
+	g.message = message
+
+	// The message is encoded once to report its errors early
+	if g.method != nil && len(errs.Errors) == 0 {
+		_, err := g.marshal(g.method, RequestInfo{})
+		errs.add("message", err)
+	}
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// compile compiles the .proto files of c, reading them and their
+// imports from Sources or else from disk, see readProto, and adding
+// them to Sources. The standard imports of google/protobuf are
+// built in.
+func (c *GRPCConfig) compile() (linker.Files, error) {
+	if c.Sources == nil {
+		c.Sources = map[string]string{}
+	}
+	var mu sync.Mutex
+	resolver := protocompile.ResolverFunc(func(name string) (protocompile.SearchResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if src, ok := c.Sources[name]; ok {
+			return protocompile.SearchResult{Source: strings.NewReader(src)}, nil
+		}
+		b, err := readProto(name, c.ImportPaths)
+		if err != nil {
+			return protocompile.SearchResult{}, err
+		}
+		c.Sources[name] = string(b)
+		return protocompile.SearchResult{Source: bytes.NewReader(b)}, nil
+	})
+
+	compiler := protocompile.Compiler{Resolver: protocompile.WithStandardImports(resolver)}
+	return compiler.Compile(context.Background(), c.ProtoFiles...)
+}
+
+// readProto reads the .proto file name, relative to the working
+// directory or, if not found there, one of the import paths.
+func readProto(name string, importPaths []string) ([]byte, error) {
+	b, err := ioutil.ReadFile(name)
+	if err == nil || !os.IsNotExist(err) || filepath.IsAbs(name) {
+		return b, err
+	}
+	for _, dir := range importPaths {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, name)); err == nil {
+			return b, nil
+		}
+	}
+	return nil, err
+}
+
+// splitMethod returns the service and the method of name, which
+// is like package.Service/Method or package.Service.Method, with
+// or without a leading slash.
+func splitMethod(name string) (string, string, error) {
+	if name == "" {
+		return "", "", fmt.Errorf("method is required, e.g. package.Service/Method")
+	}
+	full := strings.TrimPrefix(name, "/")
+	i := strings.LastIndex(full, "/")
+	if i < 0 {
+		i = strings.LastIndex(full, ".")
+	}
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid method %s, use package.Service/Method", name)
+	}
+	return full[:i], full[i+1:], nil
+}
+
+// grpcMethod returns the method name of service, if supported.
+func grpcMethod(service protoreflect.ServiceDescriptor, name string) (protoreflect.MethodDescriptor, error) {
+	m := service.Methods().ByName(protoreflect.Name(name))
+	if m == nil {
+		return nil, fmt.Errorf("unknown method %s of %s", name, service.FullName())
+	}
+	if m.IsStreamingClient() {
+		return nil, fmt.Errorf("client streaming methods, like %s, are not supported", grpcPath(m))
+	}
+	return m, nil
+}
+
+// grpcPath returns the path of the calls of m,
+// e.g. /helloworld.Greeter/SayHello.
+func grpcPath(m protoreflect.MethodDescriptor) string {
+	return "/" + string(m.Parent().FullName()) + "/" + string(m.Name())
+}
+
+// grpcCaller builds the calls of the method.
+type grpcCaller struct {
+	config  *GRPCConfig
+	message interface{}
+
+	mu     sync.Mutex
+	row    int
+	method protoreflect.MethodDescriptor
+}
+
+// name returns the name of the calls, e.g.
+// GRPC /helloworld.Greeter/SayHello.
+func (g *grpcCaller) name() string {
+	return "GRPC /" + strings.TrimPrefix(g.config.Method, "/")
+}
+
+// resolve fetches the definition of the method using server
+// reflection of the target, unless it's already known.
+func (g *grpcCaller) resolve(ctx context.Context, c *Configuration) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.method != nil {
+		return nil
+	}
+
+	conn, err := c.dialGRPC(c.URL)
+	if err != nil {
+		return fmt.Errorf("grpc %s: %v", g.config.Method, err)
+	}
+	defer conn.Close()
+
+	client := grpcreflect.NewClientAuto(metadata.NewOutgoingContext(ctx, grpcMetadata(c.Header)), conn)
+	defer client.Reset()
+
+	service, method, _ := splitMethod(g.config.Method)
+	sd, err := client.ResolveService(service)
+	if err != nil {
+		return fmt.Errorf("grpc %s: reflection: %v", g.config.Method, err)
+	}
+	m, err := grpcMethod(sd.UnwrapService(), method)
+	if err != nil {
+		return fmt.Errorf("grpc %s: %v", g.config.Method, err)
+	}
+	if _, err := g.marshal(m, RequestInfo{}); err != nil {
+		return fmt.Errorf("grpc %s: message: %v", g.config.Method, err)
+	}
+	g.method = m
+	return nil
+}
+
+// resolved returns the method, or nil if it has not been resolved.
+func (g *grpcCaller) resolved() protoreflect.MethodDescriptor {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.method
+}
+
+// marshal renders the message of info, using the next
+// row, as the input of m.
+func (g *grpcCaller) marshal(m protoreflect.MethodDescriptor, info RequestInfo) (proto.Message, error) {
+	data := rowData{RequestInfo: info}
+	if rows := g.config.Rows; len(rows) > 0 {
+		g.mu.Lock()
+		data.Row = rows[g.row%len(rows)]
+		g.row++
+		g.mu.Unlock()
+	}
+
+	in := dynamicpb.NewMessage(m.Input())
+	message, err := renderClaims(g.message, data)
+	if err != nil || g.message == nil {
+		return in, err
+	}
+	b, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return in, protojson.Unmarshal(b, in)
+}
+
+// request returns the request representing a call of the method on
+// target, without a body, which is what the hooks get to modify.
+func (g *grpcCaller) request(target *url.URL, info RequestInfo) (*http.Request, error) {
+	m := g.resolved()
+	if m == nil {
+		return nil, fmt.Errorf("grpc %s has not been resolved using server reflection, run the blast with a Runner", g.config.Method)
+	}
+
+	u := *target
+	u.Path = strings.TrimSuffix(u.Path, "/") + grpcPath(m)
+	req, err := http.NewRequest(http.MethodPost, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	return req, nil
+}
+
+// call calls the method with in on conn, reading all messages
+// of a server stream, and sets header to the response headers.
+func (g *grpcCaller) call(ctx context.Context, conn *grpc.ClientConn, in proto.Message, header *metadata.MD) error {
+	m := g.resolved()
+	if !m.IsStreamingServer() {
+		return conn.Invoke(ctx, grpcPath(m), in, dynamicpb.NewMessage(m.Output()), grpc.Header(header))
+	}
+
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, grpcPath(m))
+	if err != nil {
+		return err
+	}
+	// The status of a failed send is returned by RecvMsg
+	if err := stream.SendMsg(in); err != nil && err != io.EOF {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		err := stream.RecvMsg(dynamicpb.NewMessage(m.Output()))
+		if err == io.EOF {
+			*header, _ = stream.Header()
+			return nil
+		}
+		if err != nil {
+			*header, _ = stream.Header()
+			return err
+		}
+	}
+}
+
+// grpcReservedHeaders are the headers set by the gRPC
+// client itself, rather than sent as metadata.
+var grpcReservedHeaders = map[string]bool{
+	"host":              true,
+	"content-type":      true,
+	"content-length":    true,
+	"connection":        true,
+	"te":                true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// grpcMetadata returns the headers as the metadata of a call.
+func grpcMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, values := range header {
+		k = strings.ToLower(k)
+		if grpcReservedHeaders[k] || strings.HasPrefix(k, "grpc-") {
+			continue
+		}
+		md.Append(k, values...)
+	}
+	return md
+}
+
+// dialGRPC returns a connection to the host of target, over TLS for
+// https and in cleartext otherwise, using the dialer of the transport
+// of c. It connects when the first call is made.
+func (c *Configuration) dialGRPC(target *url.URL) (*grpc.ClientConn, error) {
+	t := c.transport().transports[0]
+	creds, port := insecure.NewCredentials(), "80"
+	if target.Scheme == "https" {
+		config := &tls.Config{}
+		if t.TLSClientConfig != nil {
+			config = t.TLSClientConfig.Clone()
+		}
+		creds, port = credentials.NewTLS(config), "443"
+	}
+	host := target.Host
+	if target.Port() == "" {
+		host = net.JoinHostPort(target.Hostname(), port)
+	}
+
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return grpc.NewClient(
+		"passthrough:///"+host,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dial(ctx, "tcp", addr)
+		}),
+	)
+}
+
+// grpcClient makes the calls of a blaster, keeping
+// a connection per host open until it's closed.
+type grpcClient struct {
+	b     *Blaster
+	conns map[string]*grpc.ClientConn
+}
+
+func newGRPCClient(b *Blaster) *grpcClient {
+	return &grpcClient{b: b, conns: map[string]*grpc.ClientConn{}}
+}
+
+// send makes the call of info and records the result, with the
+// gRPC status code rather than an HTTP status.
+func (g *grpcClient) send(info RequestInfo) {
+	start := time.Now()
+	result := RequestResult{BlasterID: g.b.id, Name: info.Name, Time: start}
+	defer func() {
+		result.Latency = time.Since(start)
+		g.b.record(result)
+	}()
+
+	req, err := g.b.config.BuildRequestFor(info)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	result.Host = req.URL.Host
+	if err := beforeRequest(g.b.config.requestHooks(), info, req); err != nil {
+		result.Err = err
+		return
+	}
+
+	caller := g.b.config.caller
+	in, err := caller.marshal(caller.resolved(), info)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	conn, reused := g.conns[req.URL.Host]
+	if !reused {
+		if conn, err = g.b.config.dialGRPC(req.URL); err != nil {
+			result.Err = err
+			return
+		}
+		g.conns[req.URL.Host] = conn
+	}
+
+	var header metadata.MD
+	ctx := metadata.NewOutgoingContext(req.Context(), grpcMetadata(req.Header))
+	err = caller.call(ctx, conn, in, &header)
+	result.Protocol = "HTTP/2.0"
+	result.BytesSent = int64(proto.Size(in))
+	result.Timing.Reused = reused
+	result.GRPCStatus = code.Code(status.Code(err)).String()
+	result.Err = err
+
+	// The hooks get the headers of the call as a response,
+	// whose HTTP status is OK whatever the gRPC status
+	res := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	for k, values := range header {
+		for _, v := range values {
+			res.Header.Add(k, v)
+		}
+	}
+	if err := afterResponse(g.b.config.requestHooks(), info, res); err != nil {
+		result.Err = err
+	}
+}
+
+// close closes the connections of g.
+func (g *grpcClient) close() {
+	for _, conn := range g.conns {
+		conn.Close()
+	}
+}
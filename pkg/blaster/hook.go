@@ -0,0 +1,108 @@
+package blaster
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestInfo describes a request sent by a blaster.
+type RequestInfo struct {
+	// BlasterID is the id of the blaster sending the request.
+	BlasterID string
+	// Iteration is the number of requests the blaster has
+	// sent before this one, i.e. it starts at zero.
+	Iteration int
+	// Session is the number of times the session of the blaster
+	// has been reset before this request, see SessionConfig.
+	Session int
+	// Name of the request, see Configuration.Name.
+	Name string
+}
+
+// Hook is called around each request sent by a blaster. Hooks are
+// registered using Configuration.Use and are called in the order
+// they were registered. They are called concurrently from all
+// blasters and must be safe for concurrent use.
+type Hook interface {
+	// BeforeRequest is called right before req is sent and may
+	// modify it, e.g. add headers. Returning an error fails the
+	// request without sending it.
+	BeforeRequest(info RequestInfo, req *http.Request) error
+	// AfterResponse is called with the response before its body has
+	// been read. Returning an error marks the request as failed.
+	AfterResponse(info RequestInfo, res *http.Response) error
+}
+
+// Preparer is implemented by hooks that need to do something
+// before the blast starts, e.g. fetch a token. Runner.Run calls
+// Prepare before starting the blasters, and fails if it does.
+type Preparer interface {
+	Prepare(ctx context.Context) error
+}
+
+// HookFuncs is a Hook using ordinary functions,
+// either of which may be nil.
+type HookFuncs struct {
+	Before func(info RequestInfo, req *http.Request) error
+	After  func(info RequestInfo, res *http.Response) error
+}
+
+// BeforeRequest calls h.Before, if set.
+func (h HookFuncs) BeforeRequest(info RequestInfo, req *http.Request) error {
+	if h.Before == nil {
+		return nil
+	}
+	return h.Before(info, req)
+}
+
+// AfterResponse calls h.After, if set.
+func (h HookFuncs) AfterResponse(info RequestInfo, res *http.Response) error {
+	if h.After == nil {
+		return nil
+	}
+	return h.After(info, res)
+}
+
+// HookError is the error of a request failed by a hook.
+type HookError struct {
+	Err error
+}
+
+func (e *HookError) Error() string {
+	return "hook: " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by the hook.
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// prepare calls Prepare of the hooks implementing Preparer.
+func prepare(ctx context.Context, hooks []Hook) error {
+	for _, h := range hooks {
+		if p, ok := h.(Preparer); ok {
+			if err := p.Prepare(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func beforeRequest(hooks []Hook, info RequestInfo, req *http.Request) error {
+	for _, h := range hooks {
+		if err := h.BeforeRequest(info, req); err != nil {
+			return &HookError{Err: err}
+		}
+	}
+	return nil
+}
+
+func afterResponse(hooks []Hook, info RequestInfo, res *http.Response) error {
+	for _, h := range hooks {
+		if err := h.AfterResponse(info, res); err != nil {
+			return &HookError{Err: err}
+		}
+	}
+	return nil
+}
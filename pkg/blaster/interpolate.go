@@ -0,0 +1,150 @@
+package blaster
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var envNameReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolate replaces ${VAR} in s with the value of the environment
+// variable VAR, and ${VAR:-default} with default if VAR is unset or
+// empty. $${ is replaced by a literal ${. It returns the result and
+// the values of the variables used.
+func interpolate(s string, lookup func(string) (string, bool)) (string, []string, error) {
+	var b strings.Builder
+	var values []string
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), values, nil
+		}
+
+		// Escaped
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			return "", nil, fmt.Errorf("missing } in %q", s[i:])
+		}
+		expr := s[i+2 : i+end]
+
+		name, def, hasDefault := expr, "", false
+		if j := strings.Index(expr, ":-"); j >= 0 {
+			name, def, hasDefault = expr[:j], expr[j+2:], true
+		}
+		if !envNameReg.MatchString(name) {
+			return "", nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+
+		value, ok := lookup(name)
+		switch {
+		case hasDefault && value == "":
+			value = def
+		case !ok:
+			return "", nil, fmt.Errorf("environment variable %s is not set", name)
+		default:
+			values = append(values, value)
+		}
+
+		b.WriteString(s[:i])
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+// interpolate interpolates environment variables in all values in
+// node. The interpolated values are kept as secrets, see isSecret.
+func (v *fileValidator) interpolate(node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			field := node.Content[i].Value
+			if path != "" {
+				field = path + "." + field
+			}
+			v.interpolate(node.Content[i+1], field)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			v.interpolate(item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case yaml.ScalarNode:
+		// A value tagged !secret is a secret wherever it's used
+		marked := node.Tag == secretTag
+		if marked {
+			node.Tag = ""
+		}
+		if !strings.Contains(node.Value, "${") {
+			if marked {
+				v.secrets = append(v.secrets, node.Value)
+			}
+			return
+		}
+
+		value, used, err := interpolate(node.Value, os.LookupEnv)
+		if err != nil {
+			v.addAt(node, path, fmt.Errorf("%s: %v", path, err))
+			return
+		}
+
+		// Resolve the type of the new value, so that e.g. both
+		// rate: ${RATE} and rate: "${RATE}" give an integer
+		node.Value = value
+		node.Tag = ""
+		node.Style = 0
+
+		if marked || isSecret(path) {
+			v.secrets = append(v.secrets, used...)
+		}
+	}
+}
+
+// secretTag marks a value in a blast file as a secret.
+const secretTag = "!secret"
+
+// credentialSections are the sections of a blast file whose
+// credential fields, see credentialFields, are secrets.
+var credentialSections = []string{"auth.", "jwt.", "signing.", "proxy."}
+
+// credentialFields are the names, or suffixes after an _, of
+// fields holding credentials.
+var credentialFields = []string{"username", "password", "secret", "token", "key", "key_id", "client_id"}
+
+// isSecret returns true if the interpolated values of the field at
+// path are kept as secrets: the credentials of auth, jwt, signing and
+// proxy, header values, the body and file names, e.g. key_file. Other
+// values, such as the URL or the name, are only secrets if tagged
+// !secret, since masking those would mask unrelated parts of the output.
+func isSecret(path string) bool {
+	if strings.HasPrefix(path, "request.body") ||
+		strings.HasPrefix(path, "request.headers[") && strings.HasSuffix(path, ".value") {
+		return true
+	}
+
+	field := path[strings.LastIndex(path, ".")+1:]
+	if strings.HasSuffix(field, "_file") {
+		return true
+	}
+	for _, section := range credentialSections {
+		if !strings.HasPrefix(path, section) {
+			continue
+		}
+		for _, name := range credentialFields {
+			if field == name || strings.HasSuffix(field, "_"+name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,450 @@
+package blaster
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The algorithms of the JWTs, see JWTConfig.
+const (
+	JWTHS256 = "HS256"
+	JWTRS256 = "RS256"
+	JWTES256 = "ES256"
+)
+
+// How often a JWT is minted, see JWTConfig.
+const (
+	MintPerRequest = "request"
+	MintPerBlaster = "blaster"
+)
+
+// JWTConfig configures the JWTs minted for the requests.
+type JWTConfig struct {
+	// Algorithm is JWTHS256, JWTRS256 or JWTES256.
+	Algorithm string `json:"algorithm"`
+	// Key is the secret of HS256, or the PEM encoded private key
+	// of RS256 and ES256. It's read from KeyFile if not given,
+	// without any trailing newline.
+	Key     string `json:"key,omitempty"`
+	KeyFile string `json:"-"`
+	// KeyID, if set, is the kid of the header of the tokens.
+	KeyID string `json:"kid,omitempty"`
+	// Claims of the tokens. The strings are templates, rendered
+	// with the RequestInfo of the request and the data Row, e.g.
+	// {{.Row.user}}. The claims iat and, if ExpiresIn is set,
+	// exp are added unless given. Templates of the claims exp,
+	// nbf and iat must render numbers, which they are sent as.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	// ExpiresIn is the lifetime of the tokens.
+	ExpiresIn time.Duration `json:"expires_in,omitempty"`
+	// Header is the header the token is set in, defaults to
+	// Authorization. Prefix is added to the token, and
+	// defaults to "Bearer " for the Authorization header.
+	Header string `json:"header,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	// Mint is MintPerRequest, the default, or MintPerBlaster to
+	// mint a token for each blaster, i.e. virtual user, that is
+	// minted again when it's about to expire or the session of
+	// the blaster is reset, see SessionConfig.
+	Mint string `json:"mint,omitempty"`
+	// Rows is data used by the claims. Each token is minted using the
+	// next row, starting over when all have been used. It's read from
+	// DataFile, a CSV file with the names of the columns on the
+	// first line, if not given.
+	Rows     []map[string]string `json:"rows,omitempty"`
+	DataFile string              `json:"-"`
+}
+
+// secrets returns the values of c that must not be printed.
+func (c JWTConfig) secrets() []string {
+	return []string{c.Key}
+}
+
+// String describes c without any secrets.
+func (c JWTConfig) String() string {
+	return fmt.Sprintf("%s JWT per %s in %s", c.Algorithm, c.Mint, c.Header)
+}
+
+// validate sets the defaults of c, reads its files and returns the
+// JWT minter, or a *ValidationError with fields such as key_file.
+func (c *JWTConfig) validate() (*jwtMinter, error) {
+	errs := &ValidationError{}
+	m := &jwtMinter{config: c, tokens: map[string]mintedToken{}}
+
+	if c.Key == "" && c.KeyFile != "" {
+		b, err := ioutil.ReadFile(c.KeyFile)
+		errs.add("key_file", err)
+		c.Key = strings.TrimRight(string(b), "\r\n")
+	}
+	keyField := "key"
+	if c.KeyFile != "" {
+		keyField = "key_file"
+	}
+
+	switch c.Algorithm {
+	case JWTHS256:
+		if c.Key == "" {
+			errs.add(keyField, fmt.Errorf("key or key_file is required"))
+		}
+		m.sign = func(b []byte) ([]byte, error) {
+			mac := hmac.New(sha256.New, []byte(c.Key))
+			mac.Write(b)
+			return mac.Sum(nil), nil
+		}
+	case JWTRS256, JWTES256:
+		sign, err := jwtPrivateKey(c.Algorithm, c.Key)
+		errs.add(keyField, err)
+		m.sign = sign
+	case "":
+		errs.add("algorithm", fmt.Errorf("algorithm is required, use %s, %s or %s", JWTHS256, JWTRS256, JWTES256))
+	default:
+		errs.add("algorithm", fmt.Errorf("unsupported algorithm %s, use %s, %s or %s", c.Algorithm, JWTHS256, JWTRS256, JWTES256))
+	}
+
+	if c.Header == "" {
+		c.Header = "Authorization"
+		if c.Prefix == "" {
+			c.Prefix = "Bearer "
+		}
+	}
+	if c.Mint == "" {
+		c.Mint = MintPerRequest
+	}
+	if c.Mint != MintPerRequest && c.Mint != MintPerBlaster {
+		errs.add("mint", fmt.Errorf("mint must be %s or %s, not %s", MintPerRequest, MintPerBlaster, c.Mint))
+	}
+	if c.ExpiresIn < 0 {
+		errs.add("expires_in", fmt.Errorf("expires_in must not be negative"))
+	}
+
+	if c.Rows == nil && c.DataFile != "" {
+		rows, err := readRows(c.DataFile)
+		errs.add("data", err)
+		c.Rows = rows
+	}
+
+	claims, err := compileClaims(c.Claims, "claims")
+	if e, ok := err.(*FieldError); ok {
+		errs.add(e.Field, e.Err)
+	}
+	m.claims = claims
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// jwtPrivateKey parses the PEM encoded private key of
+// algorithm and returns the function signing with it.
+func jwtPrivateKey(algorithm, key string) (func([]byte) ([]byte, error), error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("the key must be a PEM encoded private key")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != JWTRS256 {
+			break
+		}
+		return func(b []byte) ([]byte, error) {
+			digest := sha256.Sum256(b)
+			return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		}, nil
+	case *ecdsa.PrivateKey:
+		if algorithm != JWTES256 || key.Curve != elliptic.P256() {
+			break
+		}
+		return func(b []byte) ([]byte, error) {
+			digest := sha256.Sum256(b)
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			// The signature is r and s as fixed size big-endian integers
+			sig := make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+			return sig, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("the key is not a valid %s key", algorithm)
+}
+
+// readRows reads the rows of a CSV file, with the
+// names of the columns on the first line.
+func readRows(path string) ([]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	columns, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	rows := []map[string]string{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		row := map[string]string{}
+		for i, column := range columns {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s has no rows", path)
+	}
+	return rows, nil
+}
+
+// compileClaims replaces the strings of value by templates.
+// The returned error is a *FieldError.
+func compileClaims(value interface{}, field string) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		t, err := ParseTemplate(value)
+		if err != nil {
+			return nil, &FieldError{Field: field, Err: err}
+		}
+		return t, nil
+	case map[string]interface{}:
+		compiled := map[string]interface{}{}
+		for k, v := range value {
+			c, err := compileClaims(v, field+"."+k)
+			if err != nil {
+				return nil, err
+			}
+			compiled[k] = c
+		}
+		return compiled, nil
+	case []interface{}:
+		compiled := make([]interface{}, len(value))
+		for i, v := range value {
+			c, err := compileClaims(v, fmt.Sprintf("%s[%d]", field, i))
+			if err != nil {
+				return nil, err
+			}
+			compiled[i] = c
+		}
+		return compiled, nil
+	default:
+		return value, nil
+	}
+}
+
+// renderClaims renders the templates of compiled claims.
+func renderClaims(value interface{}, data rowData) (interface{}, error) {
+	switch value := value.(type) {
+	case *Template:
+		return value.execute(data)
+	case map[string]interface{}:
+		rendered := map[string]interface{}{}
+		for k, v := range value {
+			r, err := renderClaims(v, data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", k, err)
+			}
+			rendered[k] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(value))
+		for i, v := range value {
+			r, err := renderClaims(v, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	default:
+		return value, nil
+	}
+}
+
+// rowData is the data of templates using a row of a data
+// file, e.g. the claims of a JWT.
+type rowData struct {
+	RequestInfo
+	Row map[string]string
+}
+
+// NewJWT returns a hook that sets a JWT, minted as configured
+// by c, in each request. The returned error is a *ValidationError.
+func NewJWT(c JWTConfig) (Hook, error) {
+	m, err := c.validate()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type mintedToken struct {
+	token   string
+	expiry  time.Time
+	session int
+}
+
+// jwtMinter is a Hook that mints JWTs.
+type jwtMinter struct {
+	config *JWTConfig
+	claims interface{}
+	sign   func([]byte) ([]byte, error)
+
+	mu     sync.Mutex
+	row    int
+	tokens map[string]mintedToken
+}
+
+// BeforeRequest implements Hook.
+func (m *jwtMinter) BeforeRequest(info RequestInfo, req *http.Request) error {
+	token, err := m.token(info, time.Now())
+	if err != nil {
+		return fmt.Errorf("jwt: %v", err)
+	}
+	req.Header.Set(m.config.Header, m.config.Prefix+token)
+	return nil
+}
+
+// AfterResponse implements Hook.
+func (m *jwtMinter) AfterResponse(info RequestInfo, res *http.Response) error {
+	return nil
+}
+
+// token returns the token of a request, minted now
+// or, if minted per blaster, reused if still valid.
+func (m *jwtMinter) token(info RequestInfo, now time.Time) (string, error) {
+	if m.config.Mint == MintPerRequest {
+		return m.mint(info, now)
+	}
+
+	m.mu.Lock()
+	t, ok := m.tokens[info.BlasterID]
+	m.mu.Unlock()
+
+	// Mint a new token for a new session, or when
+	// nine tenths of the lifetime has passed
+	if ok && t.session == info.Session && (t.expiry.IsZero() || now.Before(t.expiry.Add(-m.config.ExpiresIn/10))) {
+		return t.token, nil
+	}
+
+	token, err := m.mint(info, now)
+	if err != nil {
+		return "", err
+	}
+
+	t = mintedToken{token: token, session: info.Session}
+	if m.config.ExpiresIn > 0 {
+		t.expiry = now.Add(m.config.ExpiresIn)
+	}
+	m.mu.Lock()
+	m.tokens[info.BlasterID] = t
+	m.mu.Unlock()
+	return token, nil
+}
+
+// numericDateClaims are the registered claims holding times as
+// seconds since the epoch, which must be sent as numbers.
+var numericDateClaims = []string{"exp", "nbf", "iat"}
+
+// mint returns a new token using the next row.
+func (m *jwtMinter) mint(info RequestInfo, now time.Time) (string, error) {
+	data := rowData{RequestInfo: info}
+	if rows := m.config.Rows; len(rows) > 0 {
+		m.mu.Lock()
+		data.Row = rows[m.row%len(rows)]
+		m.row++
+		m.mu.Unlock()
+	}
+
+	rendered, err := renderClaims(m.claims, data)
+	if err != nil {
+		return "", err
+	}
+	claims, _ := rendered.(map[string]interface{})
+	if claims == nil {
+		claims = map[string]interface{}{}
+	}
+	for _, name := range numericDateClaims {
+		if v, ok := claims[name].(string); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return "", fmt.Errorf("%s: %q is not a number", name, v)
+			}
+			claims[name] = n
+		}
+	}
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = now.Unix()
+	}
+	if _, ok := claims["exp"]; !ok && m.config.ExpiresIn > 0 {
+		claims["exp"] = now.Add(m.config.ExpiresIn).Unix()
+	}
+
+	header := map[string]string{"alg": m.config.Algorithm, "typ": "JWT"}
+	if m.config.KeyID != "" {
+		header["kid"] = m.config.KeyID
+	}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(h) + "." + enc.EncodeToString(c)
+	sig, err := m.sign([]byte(unsigned))
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,299 @@
+package blaster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The fields of a blast file used to combine files and profiles.
+// They're resolved before the rest of the file is validated.
+const (
+	extendsField  = "extends"
+	includeField  = "include"
+	profilesField = "profiles"
+)
+
+// load parses the blast file filename, with the content b, and
+// resolves its extends and include fields. The returned mapping
+// still holds the profiles.
+func (v *fileValidator) load(filename string, b []byte, loading []string) *yaml.Node {
+	for _, f := range loading {
+		if filepath.Clean(f) == filepath.Clean(filename) {
+			v.addAt(nil, extendsField, fmt.Errorf("%s extends itself: %s", filename, strings.Join(append(loading, filename), " -> ")))
+			return nil
+		}
+	}
+	loading = append(loading, filename)
+
+	doc := v.parse(filename, b)
+	if doc == nil {
+		return nil
+	}
+	v.setFile(doc, filename)
+
+	// The file extends its base, and the profiles it includes,
+	// so its own values take precedence over both
+	ext, _ := takeField(doc, extendsField)
+	include, _ := takeField(doc, includeField)
+	result := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	if ext != nil {
+		if !v.expect(ext, yaml.ScalarNode, "a file name", extendsField) {
+			return nil
+		}
+		if result = v.loadFile(relativeTo(filename, ext.Value), ext, loading); result == nil {
+			return nil
+		}
+	}
+
+	if include != nil && v.expect(include, yaml.SequenceNode, "a list", includeField) {
+		for i, f := range include.Content {
+			if !v.expect(f, yaml.ScalarNode, "a file name", fmt.Sprintf("%s[%d]", includeField, i)) {
+				continue
+			}
+			inc := v.loadFile(relativeTo(filename, f.Value), f, loading)
+			if inc == nil {
+				continue
+			}
+
+			// Only the profiles are included
+			if profiles := getField(inc, profilesField); profiles != nil {
+				result = v.merge(result, &yaml.Node{
+					Kind: yaml.MappingNode,
+					Tag:  "!!map",
+					Content: []*yaml.Node{
+						{Kind: yaml.ScalarNode, Tag: "!!str", Value: profilesField},
+						profiles,
+					},
+				})
+			}
+		}
+	}
+
+	return v.merge(result, doc)
+}
+
+// loadFile loads the file at filename, which was referred to by ref.
+func (v *fileValidator) loadFile(filename string, ref *yaml.Node, loading []string) *yaml.Node {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		v.addAt(ref, "", err)
+		return nil
+	}
+	return v.load(filename, b, loading)
+}
+
+// profile returns the mapping of the given profile in doc, merged
+// with the profile it extends, or the defaults. An empty profile
+// gives the defaults, i.e. doc without the profiles.
+func (v *fileValidator) profile(doc *yaml.Node, name string) *yaml.Node {
+	profiles, _ := takeField(doc, profilesField)
+	if name == "" {
+		return doc
+	}
+
+	if profiles == nil || !v.expect(profiles, yaml.MappingNode, "a mapping", profilesField) {
+		v.addAt(nil, profilesField, fmt.Errorf("unknown profile %s, the file has no profiles", name))
+		return nil
+	}
+	return v.resolveProfile(doc, profiles, name, nil)
+}
+
+func (v *fileValidator) resolveProfile(defaults, profiles *yaml.Node, name string, extending []string) *yaml.Node {
+	for _, p := range extending {
+		if p == name {
+			v.addAt(nil, profilesField, fmt.Errorf("profile %s extends itself: %s", name, strings.Join(append(extending, name), " -> ")))
+			return nil
+		}
+	}
+	extending = append(extending, name)
+
+	node := getField(profiles, name)
+	if node == nil {
+		msg := fmt.Sprintf("unknown profile %s", name)
+		if names := profileNames(profiles); len(names) > 0 {
+			msg += fmt.Sprintf(", the profiles are: %s", strings.Join(names, ", "))
+		}
+		v.addAt(nil, profilesField, fmt.Errorf("%s", msg))
+		return nil
+	}
+
+	field := profilesField + "." + name
+	if !v.expect(node, yaml.MappingNode, "a mapping", field) {
+		return nil
+	}
+
+	// Don't modify the profile, it may be extended by others
+	node = copyMapping(node)
+	v.setFile(node, v.files[getField(profiles, name)])
+
+	base := defaults
+	if ext, _ := takeField(node, extendsField); ext != nil {
+		if !v.expect(ext, yaml.ScalarNode, "a profile name", field+"."+extendsField) {
+			return nil
+		}
+		if base = v.resolveProfile(defaults, profiles, ext.Value, extending); base == nil {
+			return nil
+		}
+	}
+	return v.merge(base, node)
+}
+
+// profileNames returns the sorted names of the profiles.
+func profileNames(profiles *yaml.Node) []string {
+	if profiles == nil || profiles.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	names := []string{}
+	for i := 0; i+1 < len(profiles.Content); i += 2 {
+		names = append(names, profiles.Content[i].Value)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// merge returns base with the values of override, merging mappings
+// recursively. Lists of mappings with a name, e.g. headers, are merged
+// by name, while other values of override replace those of base. The
+// nodes are not modified.
+func (v *fileValidator) merge(base, override *yaml.Node) *yaml.Node {
+	if base.Kind == yaml.AliasNode {
+		base = base.Alias
+	}
+	if override.Kind == yaml.AliasNode {
+		override = override.Alias
+	}
+
+	switch {
+	case base.Kind == yaml.MappingNode && override.Kind == yaml.MappingNode:
+		merged := copyMapping(base)
+		v.setFile(merged, v.files[override])
+		merged.Line, merged.Column = override.Line, override.Column
+
+		for i := 0; i+1 < len(override.Content); i += 2 {
+			key, value := override.Content[i], override.Content[i+1]
+			if j := fieldIndex(merged, key.Value); j >= 0 {
+				merged.Content[j+1] = v.merge(merged.Content[j+1], value)
+			} else {
+				merged.Content = append(merged.Content, key, value)
+			}
+		}
+		return merged
+	case base.Kind == yaml.SequenceNode && override.Kind == yaml.SequenceNode && namedItems(base) && namedItems(override):
+		merged := &yaml.Node{Kind: yaml.SequenceNode, Tag: override.Tag, Line: override.Line, Column: override.Column}
+		merged.Content = append(merged.Content, base.Content...)
+		v.setFile(merged, v.files[override])
+
+		for _, item := range override.Content {
+			name := getField(item, "name").Value
+			replaced := false
+			for i, existing := range merged.Content {
+				if getField(existing, "name").Value == name {
+					merged.Content[i] = v.merge(existing, item)
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				merged.Content = append(merged.Content, item)
+			}
+		}
+		return merged
+	default:
+		return override
+	}
+}
+
+// namedItems returns true if all items of the list are mappings with a name.
+func namedItems(list *yaml.Node) bool {
+	for _, item := range list.Content {
+		if item.Kind != yaml.MappingNode {
+			return false
+		}
+		if name := getField(item, "name"); name == nil || name.Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
+
+// setFile records that node, and the nodes in it
+// not already known, are from the given file.
+func (v *fileValidator) setFile(node *yaml.Node, filename string) {
+	if node == nil || filename == "" {
+		return
+	}
+	if _, ok := v.files[node]; !ok {
+		v.files[node] = filename
+	}
+	for _, n := range node.Content {
+		v.setFile(n, filename)
+	}
+}
+
+func fieldIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// getField returns the value of key in mapping, or nil.
+func getField(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	if i := fieldIndex(mapping, key); i >= 0 {
+		return mapping.Content[i+1]
+	}
+	return nil
+}
+
+// takeField removes key from mapping and returns its value and key node.
+func takeField(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	i := fieldIndex(mapping, key)
+	if i < 0 {
+		return nil, nil
+	}
+	k, value := mapping.Content[i], mapping.Content[i+1]
+	mapping.Content = append(mapping.Content[:i:i], mapping.Content[i+2:]...)
+	return value, k
+}
+
+func copyMapping(node *yaml.Node) *yaml.Node {
+	c := *node
+	c.Content = append([]*yaml.Node(nil), node.Content...)
+	return &c
+}
+
+// relativeTo returns path relative to the directory of filename.
+func relativeTo(filename, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(filename), path)
+}
+
+// ListProfiles returns the sorted names of the profiles
+// in the blast file, including those it extends or includes.
+func ListProfiles(filename string) ([]string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	v := newFileValidator(filename)
+	doc := v.load(filename, b, nil)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return profileNames(getField(doc, profilesField)), nil
+}
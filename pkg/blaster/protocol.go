@@ -0,0 +1,220 @@
+package blaster
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// The HTTP protocols of the requests, see HTTPConfig.
+const (
+	// ProtocolAuto uses HTTP/2 if the server supports it
+	// over TLS, and HTTP/1.1 otherwise.
+	ProtocolAuto = "auto"
+	// ProtocolHTTP1 forces HTTP/1.1.
+	ProtocolHTTP1 = "http1"
+	// ProtocolHTTP2 forces HTTP/2 over TLS, failing
+	// requests to servers that don't support it.
+	ProtocolHTTP2 = "http2"
+	// ProtocolH2C uses cleartext HTTP/2 with prior knowledge,
+	// i.e. without upgrading from HTTP/1.1.
+	ProtocolH2C = "h2c"
+)
+
+// HTTPConfig configures the HTTP protocol and the connections
+// of the requests. By default the protocol is negotiated and
+// the connections are opened as needed.
+type HTTPConfig struct {
+	// Protocol is ProtocolAuto, the default, ProtocolHTTP1,
+	// ProtocolHTTP2 or ProtocolH2C.
+	Protocol string `json:"protocol,omitempty"`
+	// Connections, if positive, is the number of connections per
+	// host that the requests of all blasters are spread over.
+	Connections int `json:"connections,omitempty"`
+	// MaxStreams, if positive, limits the concurrent requests on
+	// each connection, i.e. the HTTP/2 streams. A request waits
+	// until a stream is available.
+	MaxStreams int `json:"max_streams,omitempty"`
+}
+
+// String describes c.
+func (c HTTPConfig) String() string {
+	s := c.Protocol
+	if c.Connections > 0 {
+		s += fmt.Sprintf(", %d connections", c.Connections)
+	}
+	if c.MaxStreams > 0 {
+		s += fmt.Sprintf(", at most %d streams per connection", c.MaxStreams)
+	}
+	return s
+}
+
+// validate sets the defaults of c and returns a *ValidationError
+// with fields such as protocol, or nil.
+func (c *HTTPConfig) validate() error {
+	errs := &ValidationError{}
+	if c.Protocol == "" {
+		c.Protocol = ProtocolAuto
+	}
+	switch c.Protocol {
+	case ProtocolAuto, ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C:
+	default:
+		errs.add("protocol", fmt.Errorf("unsupported protocol %s, use %s, %s, %s or %s", c.Protocol, ProtocolAuto, ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C))
+	}
+	if c.Connections < 0 {
+		errs.add("connections", fmt.Errorf("connections must not be negative"))
+	}
+	if c.MaxStreams < 0 {
+		errs.add("max_streams", fmt.Errorf("max_streams must not be negative"))
+	}
+	return errs.err()
+}
+
+// configure makes t use the protocol of c.
+func (c HTTPConfig) configure(t *http.Transport) {
+	protocols := &http.Protocols{}
+	switch c.Protocol {
+	case ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case ProtocolHTTP2:
+		protocols.SetHTTP2(true)
+	case ProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	t.Protocols = protocols
+
+	// Each transport of the pool keeps a single connection per host,
+	// which also holds for HTTP/2 when the streams run out
+	if c.Connections > 0 {
+		t.MaxConnsPerHost = 1
+	}
+}
+
+// pool is the transport of the requests, spreading them over one or
+// more transports, i.e. connections per host, and limiting the
+// concurrent requests of each if streams is set.
+type pool struct {
+	// next is the index of the next transport, first in
+	// the struct to be aligned for atomic operations
+	next       uint64
+	transports []*http.Transport
+	streams    []chan struct{}
+	// tlsOnly rejects the requests not sent over TLS, since
+	// http.Transport would fall back to HTTP/1.1 for them
+	tlsOnly bool
+}
+
+// defaultPool uses http.DefaultTransport.
+var defaultPool = &pool{transports: []*http.Transport{http.DefaultTransport.(*http.Transport)}}
+
+// newPool returns a pool of t, configured by c if not nil.
+func newPool(t *http.Transport, c *HTTPConfig) *pool {
+	p := &pool{transports: []*http.Transport{t}}
+	if c == nil {
+		return p
+	}
+
+	c.configure(t)
+	p.tlsOnly = c.Protocol == ProtocolHTTP2
+	for i := 1; i < c.Connections; i++ {
+		p.transports = append(p.transports, t.Clone())
+	}
+	if c.MaxStreams > 0 {
+		for range p.transports {
+			p.streams = append(p.streams, make(chan struct{}, c.MaxStreams))
+		}
+	}
+	return p
+}
+
+// clone returns a pool with the same configuration,
+// but with connections of its own.
+func (p *pool) clone() *pool {
+	c := &pool{tlsOnly: p.tlsOnly}
+	for _, t := range p.transports {
+		c.transports = append(c.transports, t.Clone())
+	}
+	for _, s := range p.streams {
+		c.streams = append(c.streams, make(chan struct{}, cap(s)))
+	}
+	return c
+}
+
+// RoundTrip implements http.RoundTripper.
+func (p *pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	if p.tlsOnly && req.URL.Scheme != "https" {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%s requires an https URL, use %s for cleartext HTTP/2", ProtocolHTTP2, ProtocolH2C)
+	}
+
+	i := 0
+	if len(p.transports) > 1 {
+		i = int(atomic.AddUint64(&p.next, 1) % uint64(len(p.transports)))
+	}
+	if p.streams == nil {
+		return p.transports[i].RoundTrip(req)
+	}
+
+	// Use the first connection with an available stream,
+	// or wait for one on the next connection
+	acquired := false
+	for j := range p.streams {
+		select {
+		case p.streams[(i+j)%len(p.streams)] <- struct{}{}:
+			i, acquired = (i+j)%len(p.streams), true
+		default:
+		}
+		if acquired {
+			break
+		}
+	}
+	if !acquired {
+		select {
+		case p.streams[i] <- struct{}{}:
+		case <-req.Context().Done():
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, req.Context().Err()
+		}
+	}
+
+	stream := p.streams[i]
+	res, err := p.transports[i].RoundTrip(req)
+	if err != nil {
+		<-stream
+		return nil, err
+	}
+
+	// The stream is open until the body is closed
+	res.Body = &streamBody{ReadCloser: res.Body, release: func() { <-stream }}
+	return res, nil
+}
+
+// CloseIdleConnections closes the idle connections of the pool.
+func (p *pool) CloseIdleConnections() {
+	for _, t := range p.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// streamBody is the body of a response that
+// releases its stream when it's closed.
+type streamBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,303 @@
+package blaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// socksHandshakeTimeout limits the SOCKS5 handshake
+// when the dial has no earlier deadline.
+const socksHandshakeTimeout = 30 * time.Second
+
+// ProxyConfig configures the proxy that the requests are sent through.
+type ProxyConfig struct {
+	// URL of the proxy. The scheme is http or https for an HTTP
+	// proxy, using CONNECT for https targets, or socks5 for a
+	// SOCKS5 proxy, e.g. an SSH tunnel. With socks5h the host
+	// names are resolved by the proxy rather than locally.
+	// An empty URL makes the requests connect directly,
+	// ignoring HTTP_PROXY and HTTPS_PROXY.
+	URL string `json:"url,omitempty"`
+	// Username and Password authenticate to the proxy, and
+	// replace any user info of the URL.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// secrets returns the values of c that must not be printed.
+func (c ProxyConfig) secrets() []string {
+	secrets := []string{c.Password}
+	if u, err := url.Parse(c.URL); err == nil && u.User != nil {
+		password, _ := u.User.Password()
+		secrets = append(secrets, password)
+	}
+	return secrets
+}
+
+// String describes c without any secrets.
+func (c ProxyConfig) String() string {
+	u, err := c.url()
+	if err != nil || u == nil {
+		return "none"
+	}
+	return u.Redacted()
+}
+
+// url returns the URL of the proxy, with the user info
+// of Username and Password, or nil if there is none.
+func (c ProxyConfig) url() (*url.URL, error) {
+	if c.URL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username != "" {
+		u.User = url.UserPassword(c.Username, c.Password)
+	}
+	return u, nil
+}
+
+// validate returns a *ValidationError with fields such as url, or nil.
+func (c *ProxyConfig) validate() error {
+	errs := &ValidationError{}
+	u, err := c.url()
+	switch {
+	case err != nil:
+		errs.add("url", err)
+	case u == nil:
+	case u.Host == "":
+		errs.add("url", fmt.Errorf("the proxy %s has no host", c.URL))
+	case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" && u.Scheme != "socks5h":
+		errs.add("url", fmt.Errorf("unsupported proxy %s, use http, https, socks5 or socks5h", u.Scheme))
+	}
+	if c.Password != "" && c.Username == "" {
+		errs.add("username", fmt.Errorf("username is required with a password"))
+	}
+	return errs.err()
+}
+
+// configure makes t, with the dialer d, connect through the proxy.
+func (c ProxyConfig) configure(t *http.Transport, d *dialer) {
+	t.Proxy = nil
+	u, _ := c.url()
+	if u == nil {
+		return
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		s := &socks5{address: u.Host, remoteDNS: u.Scheme == "socks5h"}
+		if u.User != nil {
+			s.username = u.User.Username()
+			s.password, _ = u.User.Password()
+		}
+		d.socks = s
+	default:
+		t.Proxy = http.ProxyURL(u)
+		t.OnProxyConnectResponse = func(ctx context.Context, proxy *url.URL, req *http.Request, res *http.Response) error {
+			if res.StatusCode != http.StatusOK {
+				return &ProxyError{Proxy: proxy.Host, Err: fmt.Errorf("CONNECT %s: %s", req.Host, res.Status)}
+			}
+			return nil
+		}
+	}
+}
+
+// ProxyError is a failure to connect through the proxy,
+// i.e. before the request could be sent to the target.
+type ProxyError struct {
+	// Proxy is the host:port of the proxy.
+	Proxy string
+	Err   error
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("proxy %s: %v", e.Proxy, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// isProxyError returns true if err is a failure to connect through
+// a proxy, also one reported by http.Transport for an HTTP proxy.
+func isProxyError(err error) bool {
+	var proxyErr *ProxyError
+	var opErr *net.OpError
+	return errors.As(err, &proxyErr) || errors.As(err, &opErr) && opErr.Op == "proxyconnect"
+}
+
+// socks5 connects through a SOCKS5 proxy, see RFC 1928,
+// with username and password authentication, see RFC 1929.
+type socks5 struct {
+	address   string
+	username  string
+	password  string
+	remoteDNS bool
+}
+
+// The replies of a SOCKS5 server, by code.
+var socksReplies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// dial connects to addr, a host:port, through the proxy.
+func (s *socks5) dial(ctx context.Context, d *net.Dialer, addr string) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, &ProxyError{Proxy: s.address, Err: err}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(socksHandshakeTimeout)
+	}
+	conn.SetDeadline(deadline)
+	if err := s.connect(ctx, conn, addr); err != nil {
+		conn.Close()
+		return nil, &ProxyError{Proxy: s.address, Err: err}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect authenticates to the proxy on conn and
+// makes it connect to addr.
+func (s *socks5) connect(ctx context.Context, conn net.Conn, addr string) error {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", portString)
+	}
+
+	// Negotiate the authentication method
+	methods := []byte{0x00}
+	if s.username != "" {
+		methods = append(methods, 0x02)
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("not a SOCKS5 server, version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if err := s.authenticate(conn); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("no acceptable authentication method")
+	}
+
+	// Request a connection to the target
+	req := []byte{0x05, 0x01, 0x00}
+	ip := net.ParseIP(host)
+	if ip == nil && !s.remoteDNS {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		ip = ips[0].IP
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("the host name %s is too long", host)
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	case ip.To4() != nil:
+		req = append(req, 0x01)
+		req = append(req, ip.To4()...)
+	default:
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// The reply ends with the bound address, which is not used
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		if int(header[1]) < len(socksReplies) {
+			return fmt.Errorf("connect %s: %s", addr, socksReplies[header[1]])
+		}
+		return fmt.Errorf("connect %s: unknown reply %d", addr, header[1])
+	}
+
+	var length int
+	switch header[3] {
+	case 0x01:
+		length = net.IPv4len
+	case 0x04:
+		length = net.IPv6len
+	case 0x03:
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		length = int(b[0])
+	default:
+		return fmt.Errorf("unknown address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, length+2))
+	return err
+}
+
+// authenticate authenticates with the username and password.
+func (s *socks5) authenticate(conn net.Conn) error {
+	if len(s.username) > 255 || len(s.password) > 255 {
+		return fmt.Errorf("the username and password must be at most 255 bytes")
+	}
+
+	req := []byte{0x01, byte(len(s.username))}
+	req = append(req, s.username...)
+	req = append(req, byte(len(s.password)))
+	req = append(req, s.password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return fmt.Errorf("authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package blaster
+
+import (
+	"context"
+	"time"
+)
+
+// Runner runs a complete blast: it creates the blasters, starts them,
+// waits for them to finish and summarizes the outcome. It's the
+// intended entry point for using go-blast as a library.
+//
+//	runner, err := blaster.NewRunner(config, 10)
+//	if err != nil {
+//		return err
+//	}
+//	runner.OnResult = func(r blaster.RequestResult) { ... }
+//	result, err := runner.Run(ctx)
+type Runner struct {
+	// OnResult, if set, is called with the outcome of each request.
+	// It's called concurrently from all blasters and should return
+	// quickly, since it blocks the calling blaster.
+	OnResult func(RequestResult)
+
+	group *Group
+}
+
+// Result summarizes a blast.
+type Result struct {
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Elapsed time.Duration `json:"elapsed"`
+	// Blasters is the number of blasters at the end of the blast.
+	Blasters     int     `json:"blasters"`
+	TargetRate   float64 `json:"target_rate"`
+	AchievedRate float64 `json:"achieved_rate"`
+	// Snapshot holds the statistics of all requests.
+	Snapshot    Snapshot     `json:"snapshot"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// NewRunner creates a runner for a blast with numBlasters
+// blasters using config.
+func NewRunner(config *Configuration, numBlasters int) (*Runner, error) {
+	group, err := NewGroup(config, numBlasters)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{group: group}, nil
+}
+
+// Group returns the group of blasters used by the runner. It can be
+// used to follow or control the blast while it's running.
+func (r *Runner) Group() *Group {
+	return r.group
+}
+
+// Run runs the blast and blocks until it's done or ctx is done.
+// If ctx is done the blasters are stopped and the result so
+// far is returned together with the error of ctx. If a hook
+// fails to prepare, see Preparer, or the gRPC method fails to be
+// resolved using server reflection, no result is returned.
+func (r *Runner) Run(ctx context.Context) (*Result, error) {
+	if err := prepare(ctx, r.group.config.hooks); err != nil {
+		return nil, err
+	}
+	if caller := r.group.config.caller; caller != nil {
+		if err := caller.resolve(ctx, r.group.config); err != nil {
+			return nil, err
+		}
+	}
+	r.group.setOnResult(r.OnResult)
+
+	start := time.Now()
+	r.group.Start()
+
+	done := make(chan struct{})
+	go func() {
+		r.group.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		r.group.Stop()
+		<-done
+	}
+
+	return &Result{
+		Start:        start,
+		End:          time.Now(),
+		Elapsed:      r.group.Elapsed(),
+		Blasters:     r.group.NumBlasters(),
+		TargetRate:   r.group.TargetRate(),
+		AchievedRate: r.group.AchievedRate(),
+		Snapshot:     r.group.Snapshot(),
+		Annotations:  r.group.Annotations(),
+	}, err
+}
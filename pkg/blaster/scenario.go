@@ -0,0 +1,65 @@
+package blaster
+
+import (
+	"net/http"
+)
+
+// Scenario replaces the configured request with custom logic, e.g.
+// a script, that each blaster runs as a virtual user. The virtual
+// user is given one iteration for each tick of the blaster.
+type Scenario interface {
+	// NewVU creates the virtual user of a blaster.
+	NewVU(ctx VUContext) (VU, error)
+}
+
+// VU is a virtual user of a scenario, run by a single blaster.
+type VU interface {
+	// Iterate runs one iteration. A returned error is recorded
+	// as a failed request named by ScenarioRequestName.
+	Iterate(info RequestInfo) error
+	// Close releases the resources of the virtual user.
+	Close()
+}
+
+// ScenarioRequestName is the name of the request recorded when
+// an iteration of a scenario fails.
+const ScenarioRequestName = "scenario"
+
+// VUContext is what a virtual user can use to send requests
+// and report results.
+type VUContext struct {
+	// BlasterID is the id of the blaster running the virtual user.
+	BlasterID string
+	// Config is the configuration of the blast.
+	Config *Configuration
+	// Do sends req through the hooks of the configuration, records
+	// the outcome as a request with the given name and returns the
+	// result together with the response and its body.
+	Do func(info RequestInfo, req *http.Request) (RequestResult, *http.Response, []byte)
+	// Check records the outcome of a check.
+	Check func(name string, passed bool)
+	// Done is closed when the blaster stops, either by Stop or
+	// when its duration has passed, so that a virtual user
+	// waiting for something can give up.
+	Done <-chan struct{}
+}
+
+// ScenarioError is the error of a failed iteration of a scenario.
+type ScenarioError struct {
+	Err error
+}
+
+func (e *ScenarioError) Error() string {
+	return "scenario: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the iteration.
+func (e *ScenarioError) Unwrap() error {
+	return e.Err
+}
+
+// CheckStats counts the outcomes of a check.
+type CheckStats struct {
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+}
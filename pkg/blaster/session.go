@@ -0,0 +1,111 @@
+package blaster
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+)
+
+// SessionConfig configures the session of each blaster, i.e. what
+// a virtual user keeps between its iterations. By default the
+// blasters share the connections and keep no cookies.
+type SessionConfig struct {
+	// Cookies makes each blaster keep the cookies set by the
+	// responses in a cookie jar of its own, and send them
+	// with its later requests.
+	Cookies bool `json:"cookies"`
+	// Connections makes each blaster use a connection pool of
+	// its own, rather than sharing one with the other blasters.
+	Connections bool `json:"connections"`
+	// ResetEvery, if positive, makes each blaster start a new session
+	// every ResetEvery iterations, i.e. discard its cookies and close
+	// its connections, modelling users leaving and new ones arriving.
+	ResetEvery int `json:"reset_every,omitempty"`
+}
+
+// String describes c.
+func (c SessionConfig) String() string {
+	kept := []string{}
+	if c.Cookies {
+		kept = append(kept, "cookies")
+	}
+	if c.Connections {
+		kept = append(kept, "connections")
+	}
+
+	s := "per blaster " + strings.Join(kept, " and ")
+	if c.ResetEvery > 0 {
+		s += fmt.Sprintf(", reset every %d iterations", c.ResetEvery)
+	}
+	return s
+}
+
+// validate returns a *ValidationError with fields
+// such as reset_every, or nil.
+func (c *SessionConfig) validate() error {
+	errs := &ValidationError{}
+	if c.ResetEvery < 0 {
+		errs.add("reset_every", fmt.Errorf("reset_every must not be negative"))
+	}
+	if !c.Cookies && !c.Connections {
+		errs.add("cookies", fmt.Errorf("a session must keep cookies, connections or both"))
+	}
+	return errs.err()
+}
+
+// session is the HTTP client of a blaster, with
+// the cookies and connections of its session.
+type session struct {
+	config    SessionConfig
+	client    *http.Client
+	transport *pool
+	// resets is the number of times the session has been reset
+	resets int
+}
+
+// newSession returns the session of a blaster using
+// the session and transport of config.
+func newSession(config *Configuration) *session {
+	s := &session{
+		client:    &http.Client{Transport: config.transport()},
+		transport: config.transport(),
+	}
+	if config.session != nil {
+		s.config = *config.session
+		s.start()
+	}
+	return s
+}
+
+// start starts a new session, with an empty cookie jar
+// and a new connection pool, as configured.
+func (s *session) start() {
+	if s.config.Cookies {
+		// cookiejar.New only fails for invalid options
+		jar, _ := cookiejar.New(nil)
+		s.client.Jar = jar
+	}
+	if s.config.Connections {
+		s.close()
+		s.client.Transport = s.transport.clone()
+	}
+}
+
+// next is called before each iteration, and resets
+// the session when it has been used for ResetEvery
+// iterations.
+func (s *session) next(iteration int) {
+	if s.config.ResetEvery > 0 && iteration > 0 && iteration%s.config.ResetEvery == 0 {
+		s.resets++
+		s.start()
+	}
+}
+
+// close closes the idle connections of the session,
+// if it has a connection pool of its own.
+func (s *session) close() {
+	if p, ok := s.client.Transport.(*pool); ok && p != s.transport {
+		p.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,438 @@
+package blaster
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The types of request signing, see SigningConfig.
+const (
+	SigningAWS  = "aws_sigv4"
+	SigningHMAC = "hmac"
+)
+
+// SigningConfig configures how the requests are signed. The
+// signature is added after all hooks, e.g. authentication, and
+// after the body has been rendered, right before each request
+// is sent. A streamed body, e.g. multipart, is read into
+// memory to be signed.
+type SigningConfig struct {
+	// Type is SigningAWS or SigningHMAC.
+	Type string `json:"type"`
+
+	// Service and Region are the scope of an AWS signature. The region
+	// and credentials default to the environment variables AWS_REGION,
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+	Service         string `json:"service,omitempty"`
+	Region          string `json:"region,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+
+	// Secret is the key of a HMAC signature, see HMACSigner.
+	Secret string `json:"secret,omitempty"`
+	// Algorithm is sha256, the default, sha1 or sha512.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Header is the header the signature is set in,
+	// defaults to X-Signature.
+	Header string `json:"header,omitempty"`
+	// Headers are the names of the headers that are signed.
+	Headers []string `json:"headers,omitempty"`
+	// Body is true if the body is signed.
+	Body bool `json:"body,omitempty"`
+	// TimestampHeader, if set, is set to the current Unix
+	// time of each request, and is signed.
+	TimestampHeader string `json:"timestamp_header,omitempty"`
+	// Encoding of the signature, hex, the default, or base64.
+	Encoding string `json:"encoding,omitempty"`
+	// Prefix is added to the signature, e.g. sha256=.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// secrets returns the values of c that must not be printed.
+func (c SigningConfig) secrets() []string {
+	return []string{c.SecretAccessKey, c.SessionToken, c.Secret}
+}
+
+// String describes c without any secrets.
+func (c SigningConfig) String() string {
+	switch c.Type {
+	case SigningAWS:
+		return fmt.Sprintf("aws_sigv4 (%s, %s)", c.Service, c.Region)
+	case SigningHMAC:
+		return fmt.Sprintf("hmac-%s (%s)", c.Algorithm, c.Header)
+	default:
+		return c.Type
+	}
+}
+
+var signingAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// validate sets the defaults of c, including those given by the
+// environment, and returns a *ValidationError with fields
+// such as region, or nil.
+func (c *SigningConfig) validate() error {
+	errs := &ValidationError{}
+	required := func(field, value string) {
+		if value == "" {
+			errs.add(field, fmt.Errorf("%s is required by %s signing", field, c.Type))
+		}
+	}
+	fromEnv := func(value *string, names ...string) {
+		for _, name := range names {
+			if *value == "" {
+				*value = os.Getenv(name)
+			}
+		}
+	}
+
+	switch c.Type {
+	case SigningAWS:
+		fromEnv(&c.Region, "AWS_REGION", "AWS_DEFAULT_REGION")
+		if c.AccessKeyID == "" && c.SecretAccessKey == "" {
+			fromEnv(&c.AccessKeyID, "AWS_ACCESS_KEY_ID")
+			fromEnv(&c.SecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+			fromEnv(&c.SessionToken, "AWS_SESSION_TOKEN")
+		}
+		required("service", c.Service)
+		required("region", c.Region)
+		required("access_key_id", c.AccessKeyID)
+		required("secret_access_key", c.SecretAccessKey)
+	case SigningHMAC:
+		required("secret", c.Secret)
+		if c.Algorithm == "" {
+			c.Algorithm = "sha256"
+		}
+		if _, ok := signingAlgorithms[c.Algorithm]; !ok {
+			errs.add("algorithm", fmt.Errorf("unsupported algorithm %s, use sha1, sha256 or sha512", c.Algorithm))
+		}
+		if c.Header == "" {
+			c.Header = "X-Signature"
+		}
+		if c.Encoding == "" {
+			c.Encoding = "hex"
+		}
+		if c.Encoding != "hex" && c.Encoding != "base64" {
+			errs.add("encoding", fmt.Errorf("unsupported encoding %s, use hex or base64", c.Encoding))
+		}
+	case "":
+		errs.add("type", fmt.Errorf("type is required, use %s or %s", SigningAWS, SigningHMAC))
+	default:
+		errs.add("type", fmt.Errorf("unsupported signing %s, use %s or %s", c.Type, SigningAWS, SigningHMAC))
+	}
+	return errs.err()
+}
+
+// Signer signs a request.
+type Signer interface {
+	Sign(req *http.Request, t time.Time) error
+}
+
+// NewSigner returns the signer configured by c.
+func NewSigner(c SigningConfig) (Signer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return newSigner(c), nil
+}
+
+// newSigner returns the signer of a validated SigningConfig.
+func newSigner(c SigningConfig) Signer {
+	if c.Type == SigningAWS {
+		return &AWSSigner{
+			Service:         c.Service,
+			Region:          c.Region,
+			AccessKeyID:     c.AccessKeyID,
+			SecretAccessKey: c.SecretAccessKey,
+			SessionToken:    c.SessionToken,
+		}
+	}
+	return &HMACSigner{
+		Secret:          []byte(c.Secret),
+		Hash:            signingAlgorithms[c.Algorithm],
+		Header:          c.Header,
+		Headers:         c.Headers,
+		Body:            c.Body,
+		TimestampHeader: c.TimestampHeader,
+		Base64:          c.Encoding == "base64",
+		Prefix:          c.Prefix,
+	}
+}
+
+// signingHook signs each request after all other hooks.
+type signingHook struct {
+	signer Signer
+}
+
+func (h signingHook) BeforeRequest(info RequestInfo, req *http.Request) error {
+	return h.signer.Sign(req, time.Now())
+}
+
+func (h signingHook) AfterResponse(info RequestInfo, res *http.Response) error {
+	return nil
+}
+
+// AWSSigner signs requests using AWS Signature Version 4.
+type AWSSigner struct {
+	Service         string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Sign implements Signer. The host, the content type and the
+// X-Amz-* headers are signed.
+func (s *AWSSigner) Sign(req *http.Request, t time.Time) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := hexSHA256(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	if s.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = canonicalHeaderValue(values)
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalPath(req.URL.Path),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.Region, s.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// canonicalPath encodes each segment of path. The segments are
+// encoded twice for all services but S3.
+func (s *AWSSigner) canonicalPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segment = awsEscape(segment)
+		if s.Service != "s3" {
+			segment = awsEscape(segment)
+		}
+		segments[i] = segment
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the query of req sorted by encoded
+// name and then by encoded value.
+func canonicalQuery(req *http.Request) string {
+	pairs := [][2]string{}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			pairs = append(pairs, [2]string{awsEscape(name), awsEscape(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	joined := make([]string, len(pairs))
+	for i, p := range pairs {
+		joined[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(joined, "&")
+}
+
+func canonicalHeaderValue(values []string) string {
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(trimmed, ",")
+}
+
+// awsEscape percent-encodes all but the unreserved characters of RFC 3986.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// HMACSigner signs requests with a HMAC of the lines
+//
+//	METHOD
+//	/path?query
+//	name:value       (for each of Headers, and TimestampHeader)
+//	body             (if Body is true)
+//
+// joined by newlines, with the names of the headers in lower case.
+type HMACSigner struct {
+	Secret          []byte
+	Hash            func() hash.Hash
+	Header          string
+	Headers         []string
+	Body            bool
+	TimestampHeader string
+	Base64          bool
+	Prefix          string
+}
+
+// Sign implements Signer.
+func (s *HMACSigner) Sign(req *http.Request, t time.Time) error {
+	if s.TimestampHeader != "" {
+		req.Header.Set(s.TimestampHeader, strconv.FormatInt(t.Unix(), 10))
+	}
+
+	lines := []string{req.Method, req.URL.RequestURI()}
+	headers := s.Headers
+	if s.TimestampHeader != "" {
+		headers = append(headers[:len(headers):len(headers)], s.TimestampHeader)
+	}
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if strings.EqualFold(name, "host") {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		lines = append(lines, strings.ToLower(name)+":"+value)
+	}
+	if s.Body {
+		body, err := readBody(req)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(body))
+	}
+
+	mac := hmac.New(s.Hash, s.Secret)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	sum := mac.Sum(nil)
+
+	signature := hex.EncodeToString(sum)
+	if s.Base64 {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	}
+	req.Header.Set(s.Header, s.Prefix+signature)
+	return nil
+}
+
+// readBody returns the body of req, which is read into memory
+// and replaced if it can't be read again.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	req.ContentLength = int64(len(b))
+	return b, nil
+}
@@ -0,0 +1,95 @@
+package blaster
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultMetricsInterval is the default interval between
+// pushing metrics to the sinks.
+const DefaultMetricsInterval = 10 * time.Second
+
+// Metrics are the aggregated metrics of a group at a point in time.
+type Metrics struct {
+	Time           time.Time
+	Snapshot       Snapshot
+	TargetRate     float64
+	AchievedRate   float64
+	ActiveBlasters int
+}
+
+// Sink is where metrics are pushed to, e.g. a StatsD server.
+// Push is called at a regular interval during the blast with
+// the aggregated metrics since the blast started.
+type Sink interface {
+	Name() string
+	Push(m Metrics) error
+	Close() error
+}
+
+// MetricsConfig configures the metric sinks of a blast.
+type MetricsConfig struct {
+	// Interval between each push of the metrics.
+	Interval time.Duration
+	// Prefix of all metric names.
+	Prefix string
+	Sinks  []SinkConfig
+}
+
+// SinkConfig describes a single metric sink.
+type SinkConfig struct {
+	// Type of the sink: statsd, influxdb or graphite.
+	Type string
+	// Address, host:port, for the statsd and graphite sinks.
+	Address string
+	// URL of the write endpoint for the influxdb sink.
+	URL string
+}
+
+// Metrics returns the current metrics of the group.
+func (g *Group) Metrics() Metrics {
+	return Metrics{
+		Time:           time.Now(),
+		Snapshot:       g.Snapshot(),
+		TargetRate:     g.TargetRate(),
+		AchievedRate:   g.AchievedRate(),
+		ActiveBlasters: g.ActiveBlasters(),
+	}
+}
+
+// MetricsSource provides the metrics pushed to the sinks,
+// e.g. a *Group.
+type MetricsSource interface {
+	Metrics() Metrics
+}
+
+// PushMetrics pushes the metrics of src to all sinks every
+// interval until stop is closed. The metrics are pushed a last
+// time before returning. Errors from the sinks are logged.
+func PushMetrics(src MetricsSource, sinks []Sink, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = DefaultMetricsInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	push := func() {
+		m := src.Metrics()
+		for _, s := range sinks {
+			if err := s.Push(m); err != nil {
+				log.Printf("Failed to push metrics to %s: %v", s.Name(), err)
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			push()
+			return
+		case <-ticker.C:
+			push()
+		}
+	}
+}
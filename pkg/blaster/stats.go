@@ -0,0 +1,449 @@
+package blaster
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+// LatencyBuckets are the upper bounds of the buckets used by the
+// latency histograms. The last, implicit, bucket is +Inf.
+var LatencyBuckets = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// Histogram is a latency histogram with fixed buckets, see LatencyBuckets.
+// Histograms using the same buckets can be merged.
+type Histogram struct {
+	// Counts holds the number of observations per bucket, where
+	// Counts[i] is the number of observations less than or equal
+	// to LatencyBuckets[i] (but greater than the previous bound).
+	// The last element holds the observations above the last bound.
+	Counts []uint64      `json:"counts"`
+	Count  uint64        `json:"count"`
+	Sum    time.Duration `json:"sum"`
+}
+
+// Observe adds d to the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+
+	i := sort.Search(len(LatencyBuckets), func(i int) bool {
+		return d <= LatencyBuckets[i]
+	})
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// Merge adds all observations in o to h. It fails if o
+// doesn't have the buckets given by LatencyBuckets.
+func (h *Histogram) Merge(o Histogram) error {
+	if o.Count == 0 {
+		return nil
+	}
+	if len(o.Counts) != len(LatencyBuckets)+1 {
+		return fmt.Errorf("histogram has %d buckets, expected %d", len(o.Counts), len(LatencyBuckets)+1)
+	}
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+	return nil
+}
+
+// Mean returns the average of all observations.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an estimate of the q-quantile (0 <= q <= 1)
+// by interpolating linearly within the bucket it falls into.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.Count)
+	var seen uint64
+	for i, c := range h.Counts {
+		if c == 0 || float64(seen+c) < rank {
+			seen += c
+			continue
+		}
+
+		var lower time.Duration
+		if i > 0 {
+			lower = LatencyBuckets[i-1]
+		}
+		if i == len(LatencyBuckets) {
+			// There is no upper bound of the last bucket
+			return lower
+		}
+
+		upper := LatencyBuckets[i]
+		fraction := (rank - float64(seen)) / float64(c)
+		return lower + time.Duration(fraction*float64(upper-lower))
+	}
+
+	return LatencyBuckets[len(LatencyBuckets)-1]
+}
+
+// RequestStats holds the statistics for a single named request.
+type RequestStats struct {
+	Total      int            `json:"total"`
+	Successful int            `json:"successful"`
+	Status     map[int]int    `json:"status"`
+	Errors     map[string]int `json:"errors"`
+	Latency    Histogram      `json:"latency"`
+	// Phases holds the latency of each phase of the requests,
+	// keyed by the phase names, e.g. PhaseDNS.
+	Phases map[string]Histogram `json:"phases"`
+	// ReusedConnections and NewConnections count the requests
+	// sent on a reused and a new connection, respectively.
+	ReusedConnections int `json:"reused_connections"`
+	NewConnections    int `json:"new_connections"`
+	// BytesSent is the number of bytes of request bodies sent.
+	BytesSent int64 `json:"bytes_sent"`
+	// Protocols counts the responses by the negotiated
+	// protocol, e.g. HTTP/1.1 or HTTP/2.0.
+	Protocols map[string]int `json:"protocols"`
+	// GRPCStatus counts the gRPC calls by their status code,
+	// e.g. OK or UNAVAILABLE, which are not counted in Status.
+	GRPCStatus map[string]int `json:"grpc_status"`
+}
+
+func newRequestStats() *RequestStats {
+	return &RequestStats{
+		Status:     map[int]int{},
+		Errors:     map[string]int{},
+		Phases:     map[string]Histogram{},
+		Protocols:  map[string]int{},
+		GRPCStatus: map[string]int{},
+	}
+}
+
+// Merge adds all counters of o to s.
+func (s *RequestStats) Merge(o *RequestStats) error {
+	if o == nil {
+		return nil
+	}
+	if err := s.Latency.Merge(o.Latency); err != nil {
+		return fmt.Errorf("latency: %v", err)
+	}
+	for phase, h := range o.Phases {
+		merged := s.Phases[phase]
+		if err := merged.Merge(h); err != nil {
+			return fmt.Errorf("phase %s: %v", phase, err)
+		}
+		s.Phases[phase] = merged
+	}
+
+	s.Total += o.Total
+	s.Successful += o.Successful
+	for code, n := range o.Status {
+		s.Status[code] += n
+	}
+	for class, n := range o.Errors {
+		s.Errors[class] += n
+	}
+	s.ReusedConnections += o.ReusedConnections
+	s.NewConnections += o.NewConnections
+	s.BytesSent += o.BytesSent
+	for protocol, n := range o.Protocols {
+		s.Protocols[protocol] += n
+	}
+	for code, n := range o.GRPCStatus {
+		s.GRPCStatus[code] += n
+	}
+	return nil
+}
+
+// Snapshot is a point-in-time copy of the statistics of one
+// or more blasters. The zero value is not usable, use NewSnapshot.
+type Snapshot struct {
+	// Requests holds the statistics by request name.
+	Requests map[string]*RequestStats `json:"requests"`
+	// Checks holds the outcomes of the checks made by
+	// a scenario, by check name.
+	Checks map[string]*CheckStats `json:"checks"`
+	// Hosts holds the statistics of all requests by the
+	// host they were sent to, e.g. 10.0.0.1:8080.
+	Hosts map[string]*RequestStats `json:"hosts"`
+}
+
+// NewSnapshot returns an empty snapshot.
+func NewSnapshot() Snapshot {
+	return Snapshot{
+		Requests: map[string]*RequestStats{},
+		Checks:   map[string]*CheckStats{},
+		Hosts:    map[string]*RequestStats{},
+	}
+}
+
+// Merge adds all statistics in o to s. It fails if o holds
+// histograms with other buckets than s, e.g. when it has been
+// received from an agent.
+func (s Snapshot) Merge(o Snapshot) error {
+	for name, r := range o.Requests {
+		if _, ok := s.Requests[name]; !ok {
+			s.Requests[name] = newRequestStats()
+		}
+		if err := s.Requests[name].Merge(r); err != nil {
+			return fmt.Errorf("request %s: %v", name, err)
+		}
+	}
+	for host, r := range o.Hosts {
+		if _, ok := s.Hosts[host]; !ok {
+			s.Hosts[host] = newRequestStats()
+		}
+		if err := s.Hosts[host].Merge(r); err != nil {
+			return fmt.Errorf("host %s: %v", host, err)
+		}
+	}
+	for name, c := range o.Checks {
+		if c == nil {
+			continue
+		}
+		if _, ok := s.Checks[name]; !ok {
+			s.Checks[name] = &CheckStats{}
+		}
+		s.Checks[name].Passed += c.Passed
+		s.Checks[name].Failed += c.Failed
+	}
+	return nil
+}
+
+// Total returns the statistics for all requests combined.
+func (s Snapshot) Total() *RequestStats {
+	total := newRequestStats()
+	for _, r := range s.Requests {
+		total.Merge(r)
+	}
+	return total
+}
+
+// Copy returns a deep copy of s.
+func (s Snapshot) Copy() Snapshot {
+	c := NewSnapshot()
+	c.Merge(s)
+	return c
+}
+
+// stats collects the statistics of a blaster and is safe
+// for concurrent use.
+type stats struct {
+	mu       sync.Mutex
+	snapshot Snapshot
+}
+
+func newStats() *stats {
+	return &stats{snapshot: NewSnapshot()}
+}
+
+// RequestResult is the outcome of a single request. Either StatusCode
+// or Err is set, or both if the request was failed by a hook.
+type RequestResult struct {
+	// BlasterID is the id of the blaster that sent the request.
+	BlasterID string
+	// Name of the request, see Configuration.Name.
+	Name       string
+	Time       time.Time
+	StatusCode int
+	Err        error
+	Latency    time.Duration
+	Timing     Timing
+	// BytesSent is the number of bytes of the request body sent.
+	BytesSent int64
+	// Host is the host the request was sent to, if it was built.
+	Host string
+	// Protocol is the protocol of the response, e.g. HTTP/2.0.
+	Protocol string
+	// GRPCStatus is the status code of a gRPC call, e.g. OK,
+	// with Err set to the error of the status if not OK.
+	GRPCStatus string
+}
+
+// Successful returns true if a response with a status
+// code less than 400 was received.
+func (r RequestResult) Successful() bool {
+	return r.Err == nil && r.StatusCode < 400
+}
+
+// record adds the outcome of a single request.
+func (s *stats) record(result RequestResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.snapshot.Requests[result.Name]
+	if !ok {
+		r = newRequestStats()
+		s.snapshot.Requests[result.Name] = r
+	}
+	r.record(result)
+
+	if result.Host != "" {
+		h, ok := s.snapshot.Hosts[result.Host]
+		if !ok {
+			h = newRequestStats()
+			s.snapshot.Hosts[result.Host] = h
+		}
+		h.record(result)
+	}
+}
+
+// record adds the outcome of a single request to r.
+func (r *RequestStats) record(result RequestResult) {
+	r.Total++
+	r.BytesSent += result.BytesSent
+	if result.Err != nil {
+		r.Errors[ErrorClass(result.Err)]++
+	} else if result.Successful() {
+		r.Successful++
+	}
+
+	// A request failed by a hook still got a response, while
+	// a WebSocket message has no status of its own
+	if result.StatusCode == 0 && result.GRPCStatus == "" && result.Err != nil {
+		return
+	}
+
+	switch {
+	case result.GRPCStatus != "":
+		r.GRPCStatus[result.GRPCStatus]++
+	case result.StatusCode != 0:
+		r.Status[result.StatusCode]++
+	}
+	r.Latency.Observe(result.Latency)
+	if result.Protocol != "" {
+		r.Protocols[result.Protocol]++
+	}
+
+	for phase, d := range result.Timing.phases() {
+		h := r.Phases[phase]
+		h.Observe(d)
+		r.Phases[phase] = h
+	}
+	if result.Timing.Reused {
+		r.ReusedConnections++
+	} else {
+		r.NewConnections++
+	}
+}
+
+// check adds the outcome of a check.
+func (s *stats) check(name string, passed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.snapshot.Checks[name]
+	if !ok {
+		c = &CheckStats{}
+		s.snapshot.Checks[name] = c
+	}
+
+	if passed {
+		c.Passed++
+	} else {
+		c.Failed++
+	}
+}
+
+func (s *stats) copy() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot.Copy()
+}
+
+// ErrorClass returns a short, label friendly, description
+// of what kind of error err is.
+func ErrorClass(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var certErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+
+	var authErr *AuthError
+	var hookErr *HookError
+	var scenarioErr *ScenarioError
+	var disconnectErr *DisconnectError
+	var handshakeErr *HandshakeError
+	var statusErr interface{ GRPCStatus() *status.Status }
+
+	switch {
+	case errors.As(err, &authErr):
+		return "auth"
+	case errors.As(err, &hookErr):
+		return "hook"
+	case errors.As(err, &scenarioErr):
+		return "scenario"
+	case isProxyError(err):
+		return "proxy"
+	case errors.As(err, &disconnectErr):
+		return "disconnect"
+	case errors.As(err, &handshakeErr):
+		return "handshake"
+	case errors.As(err, &statusErr):
+		return "grpc"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &certErr), errors.As(err, &hostErr):
+		return "tls"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+
+	msg := err.Error()
+	if urlErr, ok := err.(*url.Error); ok {
+		msg = urlErr.Err.Error()
+	}
+
+	switch {
+	case strings.Contains(msg, "connection refused"):
+		return "connection_refused"
+	case strings.Contains(msg, "connection reset"):
+		return "connection_reset"
+	case strings.Contains(msg, "tls:"), strings.Contains(msg, "x509:"):
+		return "tls"
+	case strings.Contains(msg, "EOF"):
+		return "eof"
+	}
+
+	return "other"
+}
@@ -0,0 +1,88 @@
+package blaster
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Template is a value rendered for each request, using the syntax
+// of text/template with the RequestInfo of the request as data:
+//
+//	upload-{{.BlasterID}}-{{.Iteration}}.png
+//
+// The functions uuid, randInt (min and max, inclusive), now
+// (RFC 3339) and unix (seconds) are available as well.
+type Template struct {
+	text string
+	tmpl *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"uuid": func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		b[6] = b[6]&0x0f | 0x40 // Version 4
+		b[8] = b[8]&0x3f | 0x80 // Variant 10
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	},
+	"randInt": func(min, max int) (int, error) {
+		if max < min {
+			return 0, fmt.Errorf("randInt: max %d is less than min %d", max, min)
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(max-min+1)))
+		if err != nil {
+			return 0, err
+		}
+		return min + int(n.Int64()), nil
+	},
+	"now": func() string {
+		return time.Now().UTC().Format(time.RFC3339)
+	},
+	"unix": func() int64 {
+		return time.Now().Unix()
+	},
+}
+
+// ParseTemplate parses text as a template. Text
+// without any actions is returned as it is.
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{text: text}
+	if !strings.Contains(text, "{{") {
+		return t, nil
+	}
+
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+// Render returns the value of the template for a request.
+func (t *Template) Render(info RequestInfo) (string, error) {
+	return t.execute(info)
+}
+
+// execute renders the template with data, which is
+// a RequestInfo or a struct embedding one.
+func (t *Template) execute(data interface{}) (string, error) {
+	if t.tmpl == nil {
+		return t.text, nil
+	}
+
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// String returns the text of the template.
+func (t *Template) String() string {
+	return t.text
+}
@@ -0,0 +1,142 @@
+package blaster
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Names of the phases of a request, as used in RequestStats.Phases.
+const (
+	// PhaseDNS is the time to look up the host.
+	PhaseDNS = "dns"
+	// PhaseConnect is the time to establish the TCP connection.
+	PhaseConnect = "connect"
+	// PhaseTLS is the time of the TLS handshake.
+	PhaseTLS = "tls"
+	// PhaseFirstByte is the time from the request being written
+	// until the first byte of the response, i.e. the time spent
+	// waiting for the server.
+	PhaseFirstByte = "first_byte"
+	// PhaseTransfer is the time to read the response body.
+	PhaseTransfer = "transfer"
+)
+
+// Phases lists the names of the phases in the order they occur.
+var Phases = []string{PhaseDNS, PhaseConnect, PhaseTLS, PhaseFirstByte, PhaseTransfer}
+
+// Timing holds the durations of the phases of a single request.
+// Phases that did not occur, e.g. DNS on a reused connection,
+// are zero.
+type Timing struct {
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration
+	Transfer  time.Duration
+	// Reused is true if the request was sent on a reused connection.
+	Reused bool
+}
+
+// phases returns the durations by phase name,
+// excluding the phases that did not occur.
+func (t Timing) phases() map[string]time.Duration {
+	all := map[string]time.Duration{
+		PhaseDNS:       t.DNS,
+		PhaseConnect:   t.Connect,
+		PhaseTLS:       t.TLS,
+		PhaseFirstByte: t.FirstByte,
+		PhaseTransfer:  t.Transfer,
+	}
+
+	for name, d := range all {
+		if d == 0 {
+			delete(all, name)
+		}
+	}
+	return all
+}
+
+// tracer records the timing of a request using httptrace. The
+// request is written and the response read by different goroutines,
+// e.g. while a large body is still being sent, so it's locked.
+type tracer struct {
+	mu     sync.Mutex
+	timing Timing
+
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wrote        time.Time
+	firstByte    time.Time
+}
+
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.DNS = time.Since(t.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.Connect = time.Since(t.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.TLS = time.Since(t.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.Reused = info.Reused
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wrote = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.firstByte = time.Now()
+			if !t.wrote.IsZero() {
+				t.timing.FirstByte = t.firstByte.Sub(t.wrote)
+			}
+		},
+	}
+}
+
+// bodyRead is called when the response body has been read.
+func (t *tracer) bodyRead() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.firstByte.IsZero() {
+		t.timing.Transfer = time.Since(t.firstByte)
+	}
+}
+
+// result returns the timing of the request.
+func (t *tracer) result() Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.timing
+}
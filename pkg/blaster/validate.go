@@ -0,0 +1,321 @@
+package blaster
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError is an invalid field of a configuration. The file and
+// position are set when the field is read from a blast file.
+type FieldError struct {
+	// Field is the path of the field, e.g. request.url.
+	Field  string
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+	case e.File != "":
+		return fmt.Sprintf("%s: %v", e.File, e.Err)
+	default:
+		return e.Err.Error()
+	}
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError holds all invalid fields of a configuration.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// add adds err, if not nil, as an error of field.
+func (e *ValidationError) add(field string, err error) {
+	if err != nil {
+		e.Errors = append(e.Errors, &FieldError{Field: field, Err: err})
+	}
+}
+
+// err returns e, or nil if there are no errors.
+func (e *ValidationError) err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// fileValidator validates the structure of a blast file against the
+// type it's decoded into, and keeps the position of each field so that
+// errors found later can point to it.
+type fileValidator struct {
+	filename string
+	errs     ValidationError
+	nodes    map[string]*yaml.Node
+	// failed holds the fields with errors
+	failed map[string]bool
+	// secrets holds the secret values in the file
+	secrets []string
+	// files holds the file of each node, when
+	// combined from several files
+	files map[*yaml.Node]string
+}
+
+func newFileValidator(filename string) *fileValidator {
+	return &fileValidator{
+		filename: filename,
+		nodes:    map[string]*yaml.Node{},
+		failed:   map[string]bool{},
+		files:    map[*yaml.Node]string{},
+	}
+}
+
+// add adds err, if not nil, as an error of field, at the position of
+// the field or of its parent. It's ignored if the field, or its parent,
+// already has an error, since the value is then likely the default.
+func (v *fileValidator) add(field string, err error) {
+	if err == nil {
+		return
+	}
+	for path := field; path != ""; path = parentPath(path) {
+		if v.failed[path] {
+			return
+		}
+	}
+
+	var node *yaml.Node
+	for path := field; node == nil && path != ""; path = parentPath(path) {
+		node = v.nodes[path]
+	}
+	v.addAt(node, field, err)
+}
+
+func (v *fileValidator) addAt(node *yaml.Node, field string, err error) {
+	v.failed[field] = true
+	e := &FieldError{Field: field, File: v.filename, Err: err}
+	if node != nil {
+		e.Line, e.Column = node.Line, node.Column
+		if f, ok := v.files[node]; ok {
+			e.File = f
+		}
+	}
+	v.errs.Errors = append(v.errs.Errors, e)
+}
+
+// err returns the errors, ordered by their position, or nil.
+func (v *fileValidator) err() error {
+	sort.SliceStable(v.errs.Errors, func(i, j int) bool {
+		a, b := v.errs.Errors[i], v.errs.Errors[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.errs.err()
+}
+
+// relative returns path, given in field, relative
+// to the file that field is defined in.
+func (v *fileValidator) relative(field, path string) string {
+	filename, ok := v.files[v.nodes[field]]
+	if !ok {
+		filename = v.filename
+	}
+	return relativeTo(filename, path)
+}
+
+func parentPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// check checks that node can be decoded into a value of type t,
+// rejecting fields unknown to t.
+func (v *fileValidator) check(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if path != "" {
+		v.nodes[path] = node
+	}
+	if v.failed[path] {
+		return
+	}
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+		// An empty value leaves the default
+		return
+	}
+	if t == reflect.TypeOf(stringList{}) && node.Kind == yaml.ScalarNode {
+		// A single string is a list of one
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		v.check(node, t.Elem(), path)
+	case reflect.Struct:
+		if v.expect(node, yaml.MappingNode, "a mapping", path) {
+			v.checkStruct(node, t, path)
+		}
+	case reflect.Slice:
+		if v.expect(node, yaml.SequenceNode, "a list", path) {
+			for i, item := range node.Content {
+				v.check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case reflect.Map:
+		if v.expect(node, yaml.MappingNode, "a mapping", path) {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				v.check(node.Content[i+1], t.Elem(), path+"."+node.Content[i].Value)
+			}
+		}
+	case reflect.Int:
+		if v.expect(node, yaml.ScalarNode, "an integer", path) {
+			if _, err := strconv.Atoi(node.Value); err != nil || node.ShortTag() != "!!int" {
+				v.addAt(node, path, fmt.Errorf("%s must be an integer, not %q", path, node.Value))
+			}
+		}
+	case reflect.Bool:
+		if v.expect(node, yaml.ScalarNode, "a boolean", path) && node.ShortTag() != "!!bool" {
+			v.addAt(node, path, fmt.Errorf("%s must be true or false, not %q", path, node.Value))
+		}
+	case reflect.String:
+		v.expect(node, yaml.ScalarNode, "a string", path)
+	}
+}
+
+// expect adds an error unless node is of the given kind.
+func (v *fileValidator) expect(node *yaml.Node, kind yaml.Kind, name, path string) bool {
+	if node.Kind == kind {
+		return true
+	}
+
+	field := path
+	if field == "" {
+		field = "the file"
+	}
+	v.addAt(node, path, fmt.Errorf("%s must be %s", field, name))
+	return false
+}
+
+func (v *fileValidator) checkStruct(node *yaml.Node, t reflect.Type, path string) {
+	fields := map[string]reflect.Type{}
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+		names = append(names, name)
+	}
+
+	seen := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		field := key.Value
+		if path != "" {
+			field = path + "." + key.Value
+		}
+
+		if first, ok := seen[key.Value]; ok {
+			v.addAt(key, field, fmt.Errorf("%s is already defined at line %d", field, first.Line))
+			continue
+		}
+		seen[key.Value] = key
+
+		ft, ok := fields[key.Value]
+		if !ok {
+			msg := fmt.Sprintf("unknown field %s", field)
+			if s := closest(key.Value, names); s != "" {
+				msg += fmt.Sprintf(", did you mean %s?", s)
+			}
+			v.addAt(key, field, fmt.Errorf("%s", msg))
+			continue
+		}
+		v.check(value, ft, field)
+	}
+}
+
+// yamlLineReg matches the position in the errors of the YAML parser.
+var yamlLineReg = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// syntaxError adds an error from parsing filename.
+func (v *fileValidator) syntaxError(filename string, err error) {
+	e := &FieldError{File: filename, Err: err}
+	if m := yamlLineReg.FindStringSubmatch(err.Error()); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+		e.Column = 1
+		e.Err = fmt.Errorf("%s", m[2])
+	}
+	v.errs.Errors = append(v.errs.Errors, e)
+}
+
+// closest returns the candidate closest to name,
+// if it's close enough to likely be a typo.
+func closest(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
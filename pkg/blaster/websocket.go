@@ -0,0 +1,507 @@
+package blaster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The names of the results recorded for the connections
+// of the blasters in WebSocket mode, see WebSocketConfig.
+const (
+	// WebSocketConnectName is the name of the handshakes,
+	// with the connect time as latency.
+	WebSocketConnectName = "connect"
+	// WebSocketDisconnectName is the name of the connections
+	// closed by the server or lost during the blast.
+	WebSocketDisconnectName = "disconnect"
+)
+
+// DefaultReplyTimeout is the default time to wait for
+// the handshake and for the reply of a message.
+const DefaultReplyTimeout = 10 * time.Second
+
+// WebSocketConfig makes the blasters send messages over long-lived
+// WebSocket connections, rather than HTTP requests. The handshake is
+// sent to the URL, e.g. ws://localhost:8080/chat, with the headers and
+// hooks of the configuration, e.g. the authentication.
+//
+// Each tick of a blaster sends the next message over the next of its
+// connections, reconnecting it if it was lost. The messages are
+// recorded with the name of the configuration, and the connects and
+// disconnects with WebSocketConnectName and WebSocketDisconnectName.
+type WebSocketConfig struct {
+	// Connections is the number of concurrent connections
+	// of each blaster, 1 by default.
+	Connections int `json:"connections,omitempty"`
+	// Messages are sent in turn by each blaster. They are templates,
+	// rendered with the RequestInfo of the message and the data Row,
+	// e.g. {{.Row.user}}.
+	Messages []string `json:"messages"`
+	// Binary sends binary rather than text messages.
+	Binary bool `json:"binary,omitempty"`
+	// Expect, if set, makes each message wait for a reply containing
+	// Expect, which is a template rendered like the message, e.g.
+	// "id":{{.Iteration}}. The latency of the message is then the
+	// round trip, and other replies are ignored.
+	Expect string `json:"expect,omitempty"`
+	// Timeout limits the handshake and waiting for a reply,
+	// DefaultReplyTimeout by default.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Rows is data used by the messages. Each message is rendered using
+	// the next row, starting over when all have been used. It's read
+	// from DataFile, a CSV file with the names of the columns on the
+	// first line, if not given.
+	Rows     []map[string]string `json:"rows,omitempty"`
+	DataFile string              `json:"-"`
+}
+
+// String describes c.
+func (c WebSocketConfig) String() string {
+	plural := func(n int, noun string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, noun)
+		}
+		return fmt.Sprintf("%d %ss", n, noun)
+	}
+
+	s := plural(c.Connections, "connection") + " per blaster, " + plural(len(c.Messages), "message")
+	if c.Expect != "" {
+		s += fmt.Sprintf(", expecting replies within %v", c.Timeout)
+	}
+	return s
+}
+
+// validate sets the defaults of c, reads its data file and returns
+// the messenger, or a *ValidationError with fields such as messages.
+func (c *WebSocketConfig) validate() (*messenger, error) {
+	errs := &ValidationError{}
+	m := &messenger{config: c}
+
+	if c.Connections == 0 {
+		c.Connections = 1
+	}
+	if c.Connections < 0 {
+		errs.add("connections", fmt.Errorf("connections must not be negative"))
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultReplyTimeout
+	}
+	if c.Timeout < 0 {
+		errs.add("timeout", fmt.Errorf("timeout must not be negative"))
+	}
+
+	if len(c.Messages) == 0 {
+		errs.add("messages", fmt.Errorf("at least one message is required"))
+	}
+	for i, message := range c.Messages {
+		t, err := ParseTemplate(message)
+		if err != nil {
+			errs.add(fmt.Sprintf("messages[%d]", i), err)
+			continue
+		}
+		m.messages = append(m.messages, t)
+	}
+	if c.Expect != "" {
+		t, err := ParseTemplate(c.Expect)
+		errs.add("expect", err)
+		m.expect = t
+	}
+
+	if c.Rows == nil && c.DataFile != "" {
+		rows, err := readRows(c.DataFile)
+		errs.add("data", err)
+		c.Rows = rows
+	}
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// messenger renders the messages and expected replies.
+type messenger struct {
+	config   *WebSocketConfig
+	messages []*Template
+	expect   *Template
+
+	mu  sync.Mutex
+	row int
+}
+
+// render returns the message of info, and the reply to
+// wait for, if any, rendered using the next row.
+func (m *messenger) render(info RequestInfo) (message, expect string, err error) {
+	data := rowData{RequestInfo: info}
+	if rows := m.config.Rows; len(rows) > 0 {
+		m.mu.Lock()
+		data.Row = rows[m.row%len(rows)]
+		m.row++
+		m.mu.Unlock()
+	}
+
+	if message, err = m.messages[info.Iteration%len(m.messages)].execute(data); err != nil {
+		return
+	}
+	if m.expect != nil {
+		if expect, err = m.expect.execute(data); err == nil && expect == "" {
+			err = fmt.Errorf("the expected reply is empty")
+		}
+	}
+	return
+}
+
+// DisconnectError is a WebSocket connection that was closed
+// by the server or lost, or a message that was waiting for
+// a reply on it.
+type DisconnectError struct {
+	Err error
+}
+
+func (e *DisconnectError) Error() string {
+	return "disconnected: " + e.Err.Error()
+}
+
+// Unwrap returns the error that ended the connection.
+func (e *DisconnectError) Unwrap() error {
+	return e.Err
+}
+
+// HandshakeError is a WebSocket handshake that
+// the server did not accept.
+type HandshakeError struct {
+	// StatusCode is the status of the response,
+	// e.g. 401 if the client was not authorized.
+	StatusCode int
+	Status     string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket handshake: " + e.Status
+}
+
+// replyTimeout is a message whose reply did not arrive in time.
+// It's a timeout net.Error, to be classified as such.
+type replyTimeout struct {
+	timeout time.Duration
+}
+
+func (e replyTimeout) Error() string   { return fmt.Sprintf("no reply within %v", e.timeout) }
+func (e replyTimeout) Timeout() bool   { return true }
+func (e replyTimeout) Temporary() bool { return false }
+
+// buildHandshake returns the handshake request of a connection,
+// which is the configured request without its body.
+func (c *Configuration) buildHandshake(info RequestInfo) (*http.Request, error) {
+	target := c.URL
+	if c.balancer != nil {
+		var err error
+		if target, err = c.balancer.pick(info); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	return req, nil
+}
+
+// wsClient sends the messages of a blaster over its connections.
+type wsClient struct {
+	b      *Blaster
+	dialer *websocket.Dialer
+	conns  []*wsConn
+	next   int
+}
+
+// wsConn is a connection of a blaster, with the
+// messages waiting for a reply on it.
+type wsConn struct {
+	conn *websocket.Conn
+	host string
+	done chan struct{}
+
+	mu      sync.Mutex
+	pending []wsPending
+	closed  bool
+	lost    bool
+}
+
+// wsPending is a message waiting for a reply.
+type wsPending struct {
+	result RequestResult
+	expect string
+}
+
+// newWSClient returns the client of b, with all connections open.
+func newWSClient(b *Blaster, info RequestInfo) *wsClient {
+	t := b.config.transport().transports[0]
+	w := &wsClient{
+		b: b,
+		dialer: &websocket.Dialer{
+			NetDialContext:  t.DialContext,
+			TLSClientConfig: t.TLSClientConfig,
+			Proxy:           t.Proxy,
+		},
+		conns: make([]*wsConn, b.config.websocket.Connections),
+	}
+	for i := range w.conns {
+		w.conns[i] = w.connect(info)
+	}
+	return w
+}
+
+// connect opens a connection and records the handshake,
+// returning nil if it failed.
+func (w *wsClient) connect(info RequestInfo) *wsConn {
+	info.Name = WebSocketConnectName
+	start := time.Now()
+	result := RequestResult{BlasterID: w.b.id, Name: info.Name, Time: start}
+	defer func() {
+		result.Latency = time.Since(start)
+		w.b.record(result)
+	}()
+
+	req, err := w.b.config.buildHandshake(info)
+	if err != nil {
+		result.Err = err
+		return nil
+	}
+	result.Host = req.URL.Host
+	if result.Err = beforeRequest(w.b.config.requestHooks(), info, req); result.Err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.b.config.websocket.Timeout)
+	defer cancel()
+	conn, res, err := w.dialer.DialContext(ctx, wsURL(req.URL), req.Header)
+	if err == websocket.ErrBadHandshake && res != nil {
+		err = &HandshakeError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+	if res != nil {
+		result.StatusCode, result.Protocol = res.StatusCode, res.Proto
+		if hookErr := afterResponse(w.b.config.requestHooks(), info, res); hookErr != nil && err == nil {
+			conn.Close()
+			err = hookErr
+		}
+	}
+	if result.Err = err; err != nil {
+		return nil
+	}
+
+	c := &wsConn{conn: conn, host: result.Host, done: make(chan struct{})}
+	go w.read(c)
+	return c
+}
+
+// wsURL returns u as a ws or wss URL, since the
+// handshake may be given with an http or https URL.
+func wsURL(u *url.URL) string {
+	ws := *u
+	switch ws.Scheme {
+	case "http":
+		ws.Scheme = "ws"
+	case "https":
+		ws.Scheme = "wss"
+	}
+	return ws.String()
+}
+
+// read reads the replies of c until it's closed or lost.
+func (w *wsClient) read(c *wsConn) {
+	defer close(c.done)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		now := time.Now()
+		if err != nil {
+			w.lose(c, err, now)
+			return
+		}
+
+		reply := string(data)
+		c.mu.Lock()
+		var replied *wsPending
+		for i, p := range c.pending {
+			if strings.Contains(reply, p.expect) {
+				replied = &p
+				c.pending = append(c.pending[:i:i], c.pending[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+
+		if replied != nil {
+			replied.result.Latency = now.Sub(replied.result.Time)
+			w.b.record(replied.result)
+		}
+	}
+}
+
+// lose records that c was lost because of err, unless it
+// was closed by the blaster, failing its pending messages.
+func (w *wsClient) lose(c *wsConn, err error, now time.Time) {
+	c.mu.Lock()
+	pending, closed := c.pending, c.closed
+	c.pending, c.lost = nil, true
+	c.mu.Unlock()
+	if closed {
+		return
+	}
+
+	log.Printf("Blaster %s lost a connection to %s: %v", w.b.id, c.host, err)
+	err = &DisconnectError{Err: err}
+	w.b.record(RequestResult{
+		BlasterID: w.b.id,
+		Name:      WebSocketDisconnectName,
+		Time:      now,
+		Err:       err,
+		Host:      c.host,
+	})
+	for _, p := range pending {
+		p.result.Err = err
+		p.result.Latency = now.Sub(p.result.Time)
+		w.b.record(p.result)
+	}
+}
+
+// send sends the message of info over the next connection,
+// reconnecting it if it has been lost.
+func (w *wsClient) send(info RequestInfo) {
+	w.expire(time.Now())
+
+	i := w.next % len(w.conns)
+	w.next++
+	c := w.conns[i]
+	if c != nil && c.isLost() {
+		c.conn.Close()
+		<-c.done
+		c = nil
+	}
+	if c == nil {
+		if c = w.connect(info); c == nil {
+			return
+		}
+		w.conns[i] = c
+	}
+
+	start := time.Now()
+	result := RequestResult{
+		BlasterID: w.b.id,
+		Name:      info.Name,
+		Time:      start,
+		Host:      c.host,
+		Timing:    Timing{Reused: true},
+	}
+	message, expect, err := w.b.config.messenger.render(info)
+	if err != nil {
+		result.Err = err
+		w.b.record(result)
+		return
+	}
+	result.BytesSent = int64(len(message))
+
+	// The reply may arrive before the write returns
+	if expect != "" {
+		c.mu.Lock()
+		c.pending = append(c.pending, wsPending{result: result, expect: expect})
+		c.mu.Unlock()
+	}
+
+	messageType := websocket.TextMessage
+	if w.b.config.websocket.Binary {
+		messageType = websocket.BinaryMessage
+	}
+	err = c.conn.WriteMessage(messageType, []byte(message))
+	if expect != "" && err == nil {
+		return
+	}
+
+	// A message that failed is no longer waiting, unless it
+	// was already failed by the connection being lost
+	if expect != "" && !c.remove(start) {
+		return
+	}
+	result.Err = err
+	result.Latency = time.Since(start)
+	w.b.record(result)
+}
+
+// expire fails the messages that have waited for a reply too long.
+func (w *wsClient) expire(now time.Time) {
+	timeout := w.b.config.websocket.Timeout
+	for _, c := range w.conns {
+		if c == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		var expired []wsPending
+		waiting := c.pending[:0]
+		for _, p := range c.pending {
+			if now.Sub(p.result.Time) > timeout {
+				expired = append(expired, p)
+			} else {
+				waiting = append(waiting, p)
+			}
+		}
+		c.pending = waiting
+		c.mu.Unlock()
+
+		for _, p := range expired {
+			p.result.Err = replyTimeout{timeout: timeout}
+			p.result.Latency = now.Sub(p.result.Time)
+			w.b.record(p.result)
+		}
+	}
+}
+
+// close closes all connections. The messages
+// still waiting for a reply are not recorded.
+func (w *wsClient) close() {
+	for _, c := range w.conns {
+		if c == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		c.conn.Close()
+		<-c.done
+	}
+}
+
+// isLost returns true if the connection was closed by the server or lost.
+func (c *wsConn) isLost() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+// remove removes the message sent at start from the pending
+// messages, and returns true if it was still pending.
+func (c *wsConn) remove(start time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.pending {
+		if p.result.Time.Equal(start) {
+			c.pending = append(c.pending[:i:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+// Package control provides an HTTP API for controlling
+// a running blast.
+//
+// The following endpoints are available:
+//
+//	GET  /status              the current state of the blast
+//	POST /pause               pause all blasters
+//	POST /resume              resume all blasters
+//	POST /stop                stop the blast early
+//	POST /rate?value=N        set the rate of each blaster
+//	POST /blasters?value=N    set the number of blasters
+//
+// The value of /rate and /blasters can also be sent as a form value.
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// Status is the current state of a blast.
+type Status struct {
+	Paused             bool                 `json:"paused"`
+	Rate               int                  `json:"rate"`
+	Blasters           int                  `json:"blasters"`
+	ActiveBlasters     int                  `json:"active_blasters"`
+	Elapsed            time.Duration        `json:"elapsed"`
+	TotalRequests      int                  `json:"total_requests"`
+	SuccessfulRequests int                  `json:"successful_requests"`
+	Annotations        []blaster.Annotation `json:"annotations"`
+}
+
+// Handler returns an http.Handler for controlling group.
+func Handler(group *blaster.Group) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, group)
+	})
+	mux.Handle("/pause", post(group, func(*http.Request) error {
+		group.Pause()
+		return nil
+	}))
+	mux.Handle("/resume", post(group, func(*http.Request) error {
+		group.Resume()
+		return nil
+	}))
+	mux.Handle("/stop", post(group, func(*http.Request) error {
+		group.Stop()
+		return nil
+	}))
+	mux.Handle("/rate", post(group, func(r *http.Request) error {
+		rate, err := intValue(r)
+		if err != nil {
+			return err
+		}
+		return group.SetRate(rate)
+	}))
+	mux.Handle("/blasters", post(group, func(r *http.Request) error {
+		n, err := intValue(r)
+		if err != nil {
+			return err
+		}
+		return group.SetBlasters(n)
+	}))
+	return mux
+}
+
+// post returns a handler that only accepts POST, calls fn and
+// responds with the new status of the group.
+func post(group *blaster.Group, fn func(*http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := fn(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeStatus(w, group)
+	})
+}
+
+func intValue(r *http.Request) (int, error) {
+	v := r.FormValue("value")
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("value must be an integer: %q", v)
+	}
+	return n, nil
+}
+
+func writeStatus(w http.ResponseWriter, group *blaster.Group) {
+	total := group.Snapshot().Total()
+	annotations := group.Annotations()
+	if annotations == nil {
+		annotations = []blaster.Annotation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Status{
+		Paused:             group.Paused(),
+		Rate:               group.Rate(),
+		Blasters:           group.NumBlasters(),
+		ActiveBlasters:     group.ActiveBlasters(),
+		Elapsed:            group.Elapsed(),
+		TotalRequests:      total.Total,
+		SuccessfulRequests: total.Successful,
+		Annotations:        annotations,
+	})
+}
@@ -0,0 +1,222 @@
+package distributed
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+const (
+	// DefaultAgentPort is the port agents listen on by default.
+	DefaultAgentPort = 7070
+	// DefaultAgentAddress is the address agents listen on by default,
+	// which only accepts jobs from the same machine.
+	DefaultAgentAddress = "127.0.0.1:7070"
+	// DefaultInterval is the default interval between updates
+	// sent from an agent to the coordinator.
+	DefaultInterval = time.Second
+	// MaxBlasters is the maximum number of blasters per agent.
+	MaxBlasters = blaster.MaxBlasters
+)
+
+// Job is the work sent from the coordinator to an agent.
+type Job struct {
+	Config   *blaster.Configuration `json:"config"`
+	Blasters int                    `json:"blasters"`
+	// StartAt is when the agent should start blasting, which
+	// makes all agents start at the same time.
+	StartAt time.Time `json:"start_at"`
+	// Interval between each update sent back to the coordinator.
+	Interval time.Duration `json:"interval"`
+}
+
+// Update is the progress of a job, streamed from an agent to the
+// coordinator as newline delimited JSON while the job runs.
+type Update struct {
+	Snapshot       blaster.Snapshot `json:"snapshot"`
+	Elapsed        time.Duration    `json:"elapsed"`
+	TargetRate     float64          `json:"target_rate"`
+	ActiveBlasters int              `json:"active_blasters"`
+	// Done is true in the last update of the job.
+	Done bool `json:"done"`
+}
+
+// Agent runs jobs sent by a coordinator, one at a time.
+// It's an http.Handler accepting jobs at POST /run.
+type Agent struct {
+	// Token, if set, must be sent by the coordinator as a bearer
+	// token in the Authorization header of the jobs. It's required
+	// by Serve on other than the loopback interface.
+	Token string
+
+	mu   sync.Mutex
+	busy bool
+}
+
+// NewAgent returns a new idle agent.
+func NewAgent() *Agent {
+	return &Agent{}
+}
+
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/run" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	job := Job{}
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		http.Error(w, fmt.Sprintf("invalid job: %v", err), http.StatusBadRequest)
+		return
+	}
+	if job.Config == nil {
+		http.Error(w, "invalid job: missing configuration", http.StatusBadRequest)
+		return
+	}
+	if job.Blasters < 1 || job.Blasters > MaxBlasters {
+		http.Error(w, fmt.Sprintf("invalid job: number of blasters must be in the range 1-%d", MaxBlasters), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	if !a.acquire() {
+		http.Error(w, "agent is busy", http.StatusConflict)
+		return
+	}
+	defer a.release()
+
+	runner, err := blaster.NewRunner(job.Config, job.Blasters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	log.Printf("Received job with %d blasters, starting at %s", job.Blasters, job.StartAt.Format(time.StampMilli))
+	select {
+	case <-time.After(time.Until(job.StartAt)):
+	case <-r.Context().Done():
+		log.Print("Job canceled by the coordinator before start")
+		return
+	}
+
+	a.run(w, flusher, r, runner, job.Interval)
+}
+
+// run runs the blast and streams updates until it's done.
+func (a *Agent) run(w http.ResponseWriter, flusher http.Flusher, r *http.Request, runner *blaster.Runner, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	// The blast is stopped if the coordinator goes away
+	done := make(chan error, 1)
+	go func() {
+		_, err := runner.Run(r.Context())
+		done <- err
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	group := runner.Group()
+	encoder := json.NewEncoder(w)
+	send := func(final bool) error {
+		err := encoder.Encode(Update{
+			Snapshot:       group.Snapshot(),
+			Elapsed:        group.Elapsed(),
+			TargetRate:     group.TargetRate(),
+			ActiveBlasters: group.ActiveBlasters(),
+			Done:           final,
+		})
+		flusher.Flush()
+		return err
+	}
+
+	for {
+		select {
+		case err := <-done:
+			if err == context.Canceled {
+				log.Print("Job canceled by the coordinator")
+				return
+			} else if err != nil {
+				log.Printf("Job failed: %v", err)
+				return
+			}
+			send(true)
+			log.Print("Job done")
+			return
+		case <-ticker.C:
+			if err := send(false); err != nil {
+				log.Printf("Failed to send update: %v", err)
+			}
+		}
+	}
+}
+
+// Serve accepts jobs on l until it fails. Unless l is on the
+// loopback interface, i.e. only reachable from the same machine,
+// the agent must have a token.
+func (a *Agent) Serve(l net.Listener) error {
+	if a.Token == "" && !isLoopback(l.Addr()) {
+		return fmt.Errorf("a token is required to accept jobs on %s, which is reachable from other machines", l.Addr())
+	}
+	return http.Serve(l, a)
+}
+
+// isLoopback returns true if addr only accepts
+// connections from the same machine.
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// authorized returns true if r has the token of the agent.
+func (a *Agent) authorized(r *http.Request) bool {
+	if a.Token == "" {
+		return true
+	}
+	expected := "Bearer " + a.Token
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+func (a *Agent) acquire() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.busy {
+		return false
+	}
+	a.busy = true
+	return true
+}
+
+func (a *Agent) release() {
+	a.mu.Lock()
+	a.busy = false
+	a.mu.Unlock()
+}
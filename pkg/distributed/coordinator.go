@@ -0,0 +1,259 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// DefaultStartDelay is how long after the jobs have been sent
+// to the agents that they start, by default.
+const DefaultStartDelay = 2 * time.Second
+
+// Coordinator splits a blast across a set of agents, starts them
+// at the same time and merges their results.
+type Coordinator struct {
+	// Agents are the addresses of the agents, either as host,
+	// host:port or a full URL.
+	Agents []string
+	// StartDelay is the time between sending the jobs to the
+	// agents and the agents starting. It should be long enough
+	// for all agents to receive their jobs.
+	StartDelay time.Duration
+	// Interval between each update from the agents.
+	Interval time.Duration
+	// OnUpdate, if set, is called with the combined metrics of
+	// all agents each time an agent sends an update.
+	OnUpdate func(blaster.Metrics)
+	// Token, if set, is sent to the agents to authenticate
+	// the jobs, see Agent.Token.
+	Token string
+
+	client *http.Client
+
+	mu      sync.Mutex
+	updates map[string]Update
+}
+
+// Result is the outcome of a distributed blast.
+type Result struct {
+	// Snapshot holds the merged statistics of all agents.
+	Snapshot blaster.Snapshot
+	// Agents holds the statistics of each agent.
+	Agents map[string]blaster.Snapshot
+	// Elapsed is the longest running time of any agent.
+	Elapsed time.Duration
+}
+
+// NewCoordinator creates a coordinator for the given agents.
+func NewCoordinator(agents []string) (*Coordinator, error) {
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("at least one agent is required")
+	}
+
+	addresses := make([]string, len(agents))
+	for i, a := range agents {
+		address, err := agentURL(a)
+		if err != nil {
+			return nil, err
+		}
+		addresses[i] = address
+	}
+
+	return &Coordinator{
+		Agents:     addresses,
+		StartDelay: DefaultStartDelay,
+		Interval:   DefaultInterval,
+		client:     &http.Client{},
+	}, nil
+}
+
+// Split returns the number of blasters each agent should run
+// when there are numBlasters in total, spreading them evenly.
+func Split(numBlasters, numAgents int) []int {
+	split := make([]int, numAgents)
+	for i := range split {
+		split[i] = numBlasters / numAgents
+		if i < numBlasters%numAgents {
+			split[i]++
+		}
+	}
+	return split
+}
+
+// Run sends the blast to the agents, numBlasters in total, and
+// blocks until all of them are done. If any agent fails the
+// others are canceled.
+func (c *Coordinator) Run(ctx context.Context, config *blaster.Configuration, numBlasters int) (*Result, error) {
+	if numBlasters > MaxBlasters*len(c.Agents) {
+		return nil, fmt.Errorf("too many blasters, at most %d per agent is allowed", MaxBlasters)
+	}
+	if _, err := json.Marshal(config); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.updates = map[string]Update{}
+	startAt := time.Now().Add(c.StartDelay)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(c.Agents))
+	for i, n := range Split(numBlasters, len(c.Agents)) {
+		if n == 0 {
+			continue
+		}
+
+		job := Job{
+			Config:   config,
+			Blasters: n,
+			StartAt:  startAt,
+			Interval: c.Interval,
+		}
+
+		wg.Add(1)
+		go func(agent string) {
+			defer wg.Done()
+			if err := c.runAgent(ctx, agent, job); err != nil {
+				errs <- fmt.Errorf("agent %s: %v", agent, err)
+				cancel()
+			}
+		}(c.Agents[i])
+	}
+
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	return c.result(), nil
+}
+
+// runAgent sends the job to the agent and reads its updates until it's done.
+func (c *Coordinator) runAgent(ctx context.Context, agent string, job Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, agent+"/run", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		update := Update{}
+		if err := decoder.Decode(&update); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("connection closed before the job was done")
+			}
+			return err
+		}
+		if err := blaster.NewSnapshot().Merge(update.Snapshot); err != nil {
+			return fmt.Errorf("invalid update: %v", err)
+		}
+
+		c.update(agent, update)
+		if update.Done {
+			return nil
+		}
+	}
+}
+
+func (c *Coordinator) update(agent string, update Update) {
+	c.mu.Lock()
+	c.updates[agent] = update
+	metrics := c.metrics()
+	c.mu.Unlock()
+
+	if c.OnUpdate != nil {
+		c.OnUpdate(metrics)
+	}
+}
+
+// metrics returns the combined metrics of the latest
+// updates. The caller must hold c.mu.
+func (c *Coordinator) metrics() blaster.Metrics {
+	m := blaster.Metrics{
+		Time:     time.Now(),
+		Snapshot: blaster.NewSnapshot(),
+	}
+
+	var elapsed time.Duration
+	for _, u := range c.updates {
+		m.Snapshot.Merge(u.Snapshot)
+		m.TargetRate += u.TargetRate
+		m.ActiveBlasters += u.ActiveBlasters
+		if u.Elapsed > elapsed {
+			elapsed = u.Elapsed
+		}
+	}
+
+	if elapsed > 0 {
+		m.AchievedRate = float64(m.Snapshot.Total().Total) / elapsed.Seconds()
+	}
+	return m
+}
+
+func (c *Coordinator) result() *Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := &Result{
+		Snapshot: blaster.NewSnapshot(),
+		Agents:   map[string]blaster.Snapshot{},
+	}
+	for agent, u := range c.updates {
+		result.Snapshot.Merge(u.Snapshot)
+		result.Agents[agent] = u.Snapshot
+		if u.Elapsed > result.Elapsed {
+			result.Elapsed = u.Elapsed
+		}
+	}
+	return result
+}
+
+// agentURL returns the base URL of an agent given as
+// host, host:port or a full URL.
+func agentURL(agent string) (string, error) {
+	if !strings.Contains(agent, "://") {
+		if _, _, err := net.SplitHostPort(agent); err != nil {
+			agent = net.JoinHostPort(agent, strconv.Itoa(DefaultAgentPort))
+		}
+		agent = "http://" + agent
+	}
+
+	if _, err := http.NewRequest(http.MethodPost, agent, nil); err != nil {
+		return "", fmt.Errorf("invalid agent address %q: %v", agent, err)
+	}
+	return strings.TrimRight(agent, "/"), nil
+}
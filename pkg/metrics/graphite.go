@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// Graphite is a sink that sends metrics to Graphite (Carbon)
+// using the plaintext protocol over TCP.
+type Graphite struct {
+	prefix  string
+	address string
+	conn    net.Conn
+}
+
+// NewGraphite creates a Graphite sink sending to address (host:port).
+func NewGraphite(address, prefix string) (*Graphite, error) {
+	if address == "" {
+		return nil, fmt.Errorf("graphite: address is required")
+	}
+
+	g := &Graphite{prefix: prefix, address: address}
+	if err := g.connect(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Graphite) connect() (err error) {
+	g.conn, err = net.DialTimeout("tcp", g.address, 5*time.Second)
+	return
+}
+
+// Name returns the name of the sink.
+func (g *Graphite) Name() string {
+	return "graphite"
+}
+
+// Push sends the metrics to Graphite. If the connection has been
+// lost a new one is made on the next push.
+func (g *Graphite) Push(m blaster.Metrics) error {
+	if g.conn == nil {
+		if err := g.connect(); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	timestamp := m.Time.Unix()
+	for _, sample := range samples(m) {
+		fmt.Fprintf(&buf, "%s %s %d\n", dotted(g.prefix, sample), formatFloat(sample.value), timestamp)
+	}
+
+	if _, err := g.conn.Write(buf.Bytes()); err != nil {
+		g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection.
+func (g *Graphite) Close() error {
+	if g.conn == nil {
+		return nil
+	}
+	return g.conn.Close()
+}
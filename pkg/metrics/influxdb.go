@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// InfluxDB is a sink that writes metrics to an InfluxDB HTTP
+// write endpoint using the line protocol. Each request becomes
+// a point with a request tag, and the group level metrics a
+// point without tags.
+type InfluxDB struct {
+	prefix string
+	url    string
+	client *http.Client
+}
+
+// NewInfluxDB creates an InfluxDB sink writing to the endpoint
+// at rawURL, e.g. http://localhost:8086/write?db=blast.
+func NewInfluxDB(rawURL, prefix string) (*InfluxDB, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("influxdb: url is required")
+	}
+
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps are written in seconds
+	query := u.Query()
+	if query.Get("precision") == "" {
+		query.Set("precision", "s")
+		u.RawQuery = query.Encode()
+	}
+
+	return &InfluxDB{
+		prefix: prefix,
+		url:    u.String(),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name returns the name of the sink.
+func (s *InfluxDB) Name() string {
+	return "influxdb"
+}
+
+// Push writes the metrics to the InfluxDB endpoint.
+func (s *InfluxDB) Push(m blaster.Metrics) error {
+	var body bytes.Buffer
+	names, groups := groupByRequest(samples(m))
+	for _, name := range names {
+		body.WriteString(escapeMeasurement(s.prefix))
+		if name != "" {
+			body.WriteString(",request=")
+			body.WriteString(escapeTag(name))
+		}
+
+		for i, sample := range groups[name] {
+			if i == 0 {
+				body.WriteByte(' ')
+			} else {
+				body.WriteByte(',')
+			}
+
+			value := formatFloat(sample.value)
+			if sample.counter {
+				value = fmt.Sprintf("%di", int64(sample.value))
+			}
+			fmt.Fprintf(&body, "%s=%s", escapeTag(sample.name), value)
+		}
+		fmt.Fprintf(&body, " %d\n", m.Time.Unix())
+	}
+
+	res, err := s.client.Post(s.url, "text/plain; charset=utf-8", &body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("influxdb: unexpected response %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close does nothing since each push is a separate request.
+func (s *InfluxDB) Close() error {
+	return nil
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func escapeMeasurement(v string) string {
+	return measurementEscaper.Replace(v)
+}
+
+func escapeTag(v string) string {
+	return tagEscaper.Replace(v)
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// Source is what provides the metrics to expose,
+// typically a *blaster.Group.
+type Source interface {
+	Snapshot() blaster.Snapshot
+	TargetRate() float64
+	AchievedRate() float64
+	ActiveBlasters() int
+}
+
+// Handler returns an http.Handler serving the metrics of src
+// in the Prometheus text exposition format.
+func Handler(src Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WritePrometheus(w, src); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// WritePrometheus writes the current metrics of src to w
+// in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer, src Source) error {
+	buf := bufio.NewWriter(w)
+	snapshot := src.Snapshot()
+	names := requestNames(snapshot)
+
+	writeHeader(buf, "goblast_requests_total", "counter", "Total number of requests sent, by response status code.")
+	for _, name := range names {
+		r := snapshot.Requests[name]
+		codes := make([]int, 0, len(r.Status))
+		for code := range r.Status {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+
+		for _, code := range codes {
+			fmt.Fprintf(buf, "goblast_requests_total{request=%s,status=\"%d\"} %d\n", quote(name), code, r.Status[code])
+		}
+	}
+
+	writeHeader(buf, "goblast_grpc_calls_total", "counter", "Total number of gRPC calls, by status code.")
+	for _, name := range names {
+		r := snapshot.Requests[name]
+		for _, code := range sortedKeys(r.GRPCStatus) {
+			fmt.Fprintf(buf, "goblast_grpc_calls_total{request=%s,code=%s} %d\n", quote(name), quote(code), r.GRPCStatus[code])
+		}
+	}
+
+	writeHeader(buf, "goblast_request_errors_total", "counter", "Total number of requests that failed without a response, by error.")
+	for _, name := range names {
+		r := snapshot.Requests[name]
+		for _, class := range sortedKeys(r.Errors) {
+			fmt.Fprintf(buf, "goblast_request_errors_total{request=%s,error=%s} %d\n", quote(name), quote(class), r.Errors[class])
+		}
+	}
+
+	writeHeader(buf, "goblast_request_duration_seconds", "histogram", "Request latency in seconds.")
+	for _, name := range names {
+		writeHistogram(buf, "goblast_request_duration_seconds", "request="+quote(name), snapshot.Requests[name].Latency)
+	}
+
+	writeHeader(buf, "goblast_request_phase_duration_seconds", "histogram", "Latency of each phase of the requests in seconds.")
+	for _, name := range names {
+		phases := snapshot.Requests[name].Phases
+		for _, phase := range blaster.Phases {
+			if h, ok := phases[phase]; ok {
+				writeHistogram(buf, "goblast_request_phase_duration_seconds", "request="+quote(name)+",phase="+quote(phase), h)
+			}
+		}
+	}
+
+	writeHeader(buf, "goblast_connections_total", "counter", "Total number of requests sent on new and reused connections.")
+	for _, name := range names {
+		r := snapshot.Requests[name]
+		fmt.Fprintf(buf, "goblast_connections_total{request=%s,reused=\"false\"} %d\n", quote(name), r.NewConnections)
+		fmt.Fprintf(buf, "goblast_connections_total{request=%s,reused=\"true\"} %d\n", quote(name), r.ReusedConnections)
+	}
+
+	writeHeader(buf, "goblast_request_body_bytes_total", "counter", "Total number of bytes of request bodies sent.")
+	for _, name := range names {
+		fmt.Fprintf(buf, "goblast_request_body_bytes_total{request=%s} %d\n", quote(name), snapshot.Requests[name].BytesSent)
+	}
+
+	writeHeader(buf, "goblast_checks_total", "counter", "Total number of checks made by a scenario, by result.")
+	checks := make([]string, 0, len(snapshot.Checks))
+	for name := range snapshot.Checks {
+		checks = append(checks, name)
+	}
+	sort.Strings(checks)
+	for _, name := range checks {
+		c := snapshot.Checks[name]
+		fmt.Fprintf(buf, "goblast_checks_total{check=%s,result=\"passed\"} %d\n", quote(name), c.Passed)
+		fmt.Fprintf(buf, "goblast_checks_total{check=%s,result=\"failed\"} %d\n", quote(name), c.Failed)
+	}
+
+	writeHeader(buf, "goblast_target_rate", "gauge", "Target number of requests per second.")
+	fmt.Fprintf(buf, "goblast_target_rate %s\n", formatFloat(src.TargetRate()))
+
+	writeHeader(buf, "goblast_achieved_rate", "gauge", "Achieved number of requests per second.")
+	fmt.Fprintf(buf, "goblast_achieved_rate %s\n", formatFloat(src.AchievedRate()))
+
+	writeHeader(buf, "goblast_active_blasters", "gauge", "Number of blasters currently running.")
+	fmt.Fprintf(buf, "goblast_active_blasters %d\n", src.ActiveBlasters())
+
+	return buf.Flush()
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeHistogram(w io.Writer, name, labels string, h blaster.Histogram) {
+	var cumulative uint64
+	for i, bound := range blaster.LatencyBuckets {
+		if h.Counts != nil {
+			cumulative += h.Counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound.Seconds()), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.Sum.Seconds()))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.Count)
+}
+
+func requestNames(s blaster.Snapshot) []string {
+	names := make([]string, 0, len(s.Requests))
+	for name := range s.Requests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func quote(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// DefaultPrefix is the prefix used for metric names
+// when none is configured.
+const DefaultPrefix = "goblast"
+
+// NewSink creates the sink described by config. Metric
+// names are prefixed with prefix.
+func NewSink(config blaster.SinkConfig, prefix string) (blaster.Sink, error) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	switch strings.ToLower(config.Type) {
+	case "statsd":
+		return NewStatsD(config.Address, prefix)
+	case "influxdb", "influx":
+		return NewInfluxDB(config.URL, prefix)
+	case "graphite":
+		return NewGraphite(config.Address, prefix)
+	default:
+		return nil, fmt.Errorf("unknown metrics sink type: %q", config.Type)
+	}
+}
+
+// NewSinks creates all sinks in config.
+func NewSinks(config blaster.MetricsConfig) ([]blaster.Sink, error) {
+	sinks := make([]blaster.Sink, 0, len(config.Sinks))
+	for _, c := range config.Sinks {
+		s, err := NewSink(c, config.Prefix)
+		if err != nil {
+			for _, created := range sinks {
+				created.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// sample is a single value to push to a sink.
+type sample struct {
+	name string
+	// request is the name of the request the sample
+	// belongs to, or empty for group level metrics.
+	request string
+	value   float64
+	// counter is true if the value is a cumulative counter.
+	counter bool
+}
+
+// key identifies the sample, regardless of its value.
+func (s sample) key() string {
+	return s.request + "\x00" + s.name
+}
+
+// samples flattens m into the values pushed to the sinks.
+// Latencies are in milliseconds.
+func samples(m blaster.Metrics) []sample {
+	names := requestNames(m.Snapshot)
+	result := make([]sample, 0, len(names)*8+3)
+
+	for _, name := range names {
+		r := m.Snapshot.Requests[name]
+		errors := 0
+		for _, n := range r.Errors {
+			errors += n
+		}
+
+		result = append(result,
+			sample{name: "requests", request: name, value: float64(r.Total), counter: true},
+			sample{name: "successful", request: name, value: float64(r.Successful), counter: true},
+			sample{name: "failed", request: name, value: float64(r.Total - r.Successful - errors), counter: true},
+			sample{name: "errors", request: name, value: float64(errors), counter: true},
+			sample{name: "latency_mean", request: name, value: milliseconds(r.Latency.Mean().Seconds())},
+			sample{name: "latency_p50", request: name, value: milliseconds(r.Latency.Quantile(0.50).Seconds())},
+			sample{name: "latency_p90", request: name, value: milliseconds(r.Latency.Quantile(0.90).Seconds())},
+			sample{name: "latency_p99", request: name, value: milliseconds(r.Latency.Quantile(0.99).Seconds())},
+		)
+	}
+
+	return append(result,
+		sample{name: "target_rate", value: m.TargetRate},
+		sample{name: "achieved_rate", value: m.AchievedRate},
+		sample{name: "active_blasters", value: float64(m.ActiveBlasters)},
+	)
+}
+
+func milliseconds(seconds float64) float64 {
+	return seconds * 1000
+}
+
+// sanitize makes v usable as a part of a dot separated metric name.
+func sanitize(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, v)
+}
+
+// dotted returns the dot separated name of s.
+func dotted(prefix string, s sample) string {
+	if s.request == "" {
+		return prefix + "." + s.name
+	}
+	return prefix + ".request." + sanitize(s.request) + "." + s.name
+}
+
+// groupByRequest returns the samples grouped by request name,
+// where the group level samples have the empty name.
+func groupByRequest(samples []sample) (names []string, groups map[string][]sample) {
+	groups = map[string][]sample{}
+	for _, s := range samples {
+		if _, ok := groups[s.request]; !ok {
+			names = append(names, s.request)
+		}
+		groups[s.request] = append(groups[s.request], s)
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+)
+
+// maxStatsDPacket is the maximum size of a single UDP packet
+// sent to StatsD, chosen to avoid fragmentation.
+const maxStatsDPacket = 1432
+
+// StatsD is a sink that sends metrics to a StatsD server over UDP.
+// Counters are sent as the increase since the last push and
+// everything else as gauges.
+type StatsD struct {
+	prefix string
+	conn   net.Conn
+	last   map[string]float64
+}
+
+// NewStatsD creates a StatsD sink sending to address (host:port).
+func NewStatsD(address, prefix string) (*StatsD, error) {
+	if address == "" {
+		return nil, fmt.Errorf("statsd: address is required")
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsD{
+		prefix: prefix,
+		conn:   conn,
+		last:   map[string]float64{},
+	}, nil
+}
+
+// Name returns the name of the sink.
+func (s *StatsD) Name() string {
+	return "statsd"
+}
+
+// Push sends the metrics to the StatsD server.
+func (s *StatsD) Push(m blaster.Metrics) error {
+	var packet bytes.Buffer
+	for _, sample := range samples(m) {
+		value, kind := sample.value, "g"
+		if sample.counter {
+			value -= s.last[sample.key()]
+			s.last[sample.key()] = sample.value
+			kind = "c"
+		}
+
+		line := fmt.Sprintf("%s:%s|%s\n", dotted(s.prefix, sample), formatFloat(value), kind)
+		if packet.Len()+len(line) > maxStatsDPacket {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+
+	if packet.Len() == 0 {
+		return nil
+	}
+
+	_, err := s.conn.Write(packet.Bytes())
+	return err
+}
+
+// Close closes the connection.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,133 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// jsonEncode implements json.encode(value).
+func jsonEncode(L *lua.LState) int {
+	v, err := toGo(L.CheckAny(1), 0)
+	if err != nil {
+		L.RaiseError("json.encode: %v", err)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		L.RaiseError("json.encode: %v", err)
+	}
+
+	L.Push(lua.LString(b))
+	return 1
+}
+
+// jsonDecode implements json.decode(string).
+func jsonDecode(L *lua.LState) int {
+	var v interface{}
+	if err := json.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+		L.RaiseError("json.decode: %v", err)
+	}
+
+	L.Push(fromGo(L, v))
+	return 1
+}
+
+// maxDepth limits the nesting of tables when encoding,
+// which also protects against cyclic tables.
+const maxDepth = 100
+
+// toGo converts a Lua value to a value that can be marshaled as JSON.
+// A table is converted to an array if its keys are 1..n, otherwise
+// to an object.
+func toGo(v lua.LValue, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("tables nested too deep")
+	}
+
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		f := float64(v)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f), nil
+		}
+		return f, nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		return tableToGo(v, depth)
+	default:
+		return nil, fmt.Errorf("cannot encode value of type %s", v.Type())
+	}
+}
+
+func tableToGo(t *lua.LTable, depth int) (interface{}, error) {
+	n := t.Len()
+	count := 0
+	t.ForEach(func(lua.LValue, lua.LValue) { count++ })
+
+	if n > 0 && n == count {
+		array := make([]interface{}, n)
+		for i := 1; i <= n; i++ {
+			v, err := toGo(t.RawGetInt(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			array[i-1] = v
+		}
+		return array, nil
+	}
+
+	object := map[string]interface{}{}
+	var err error
+	t.ForEach(func(k, v lua.LValue) {
+		if err != nil {
+			return
+		}
+		var value interface{}
+		value, err = toGo(v, depth+1)
+		object[k.String()] = value
+	})
+	return object, err
+}
+
+// fromGo converts a value unmarshaled from JSON to a Lua value.
+func fromGo(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(fromGo(L, item))
+		}
+		return t
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		t := L.CreateTable(0, len(v))
+		for _, k := range keys {
+			t.RawSetString(k, fromGo(L, v[k]))
+		}
+		return t
+	default:
+		return lua.LNil
+	}
+}
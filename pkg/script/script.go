@@ -0,0 +1,191 @@
+// Package script runs blast scenarios written in Lua.
+//
+// A script must define a global function named iteration, which is
+// called once for each tick of a blaster. Each blaster is a virtual
+// user with its own Lua state, so global variables are kept between
+// the iterations of a blaster but not shared with the others.
+//
+//	function iteration(vu)
+//	  local res = http.get("/items", {name = "list items"})
+//	  check(res.status == 200, "items listed")
+//
+//	  local items = json.decode(res.body)
+//	  http.post("/orders", json.encode({item = items[1].id}), {
+//	    headers = {["Content-Type"] = "application/json"},
+//	  })
+//	  sleep(0.5)
+//	end
+//
+// The following is available to the script, in addition to the base,
+// table, string and math libraries of Lua:
+//
+//	vu.id, vu.iteration               the blaster id and iteration number
+//	vu.session                        the number of times the session was reset
+//	http.get(url, [opts])             send a GET request
+//	http.delete(url, [opts])          send a DELETE request
+//	http.post(url, body, [opts])      send a POST request
+//	http.put(url, body, [opts])       send a PUT request
+//	http.request(method, url, [body], [opts])
+//	check(value, name)                record a check, passed if value is truthy
+//	sleep(seconds)                    pause the virtual user
+//	shared.get(key)                   read a value shared by all virtual users
+//	shared.set(key, value)            set a shared value (string, number, boolean or nil)
+//	json.encode(value)                encode a Lua value as JSON
+//	json.decode(string)               decode JSON to a Lua value
+//
+// URLs are resolved relative to the URL of the blast configuration. The
+// opts table may contain headers, a table of header values added to the
+// headers of the configuration, and name, the name of the request used
+// in the statistics. Requests return a table with the fields status,
+// body, headers, latency (in seconds) and error (nil unless the request
+// failed without a response).
+package script
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// IterationFunction is the name of the function called
+// in each iteration.
+const IterationFunction = "iteration"
+
+// Script is a compiled scenario script. It implements blaster.Scenario.
+type Script struct {
+	name   string
+	proto  *lua.FunctionProto
+	shared *shared
+}
+
+// Load compiles the script in the file at path.
+func Load(path string) (*Script, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, string(b))
+}
+
+// Parse compiles the script in source, where name
+// is used in error messages.
+func Parse(name, source string) (*Script, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Script{
+		name:   name,
+		proto:  proto,
+		shared: &shared{values: map[string]lua.LValue{}},
+	}, nil
+}
+
+// NewVU creates a new virtual user running the script.
+func (s *Script) NewVU(ctx blaster.VUContext) (blaster.VU, error) {
+	vu := &VU{
+		ctx:   ctx,
+		state: newState(),
+	}
+	vu.done, vu.cancel = context.WithCancel(context.Background())
+	if ctx.Done != nil {
+		go func() {
+			select {
+			case <-ctx.Done:
+				vu.cancel()
+			case <-vu.done.Done():
+			}
+		}()
+	}
+
+	// The script is interrupted, also in a busy loop, when the blaster stops
+	vu.state.SetContext(vu.done)
+	vu.register(s.shared)
+
+	vu.state.Push(vu.state.NewFunctionFromProto(s.proto))
+	if err := vu.state.PCall(0, lua.MultRet, nil); err != nil {
+		vu.Close()
+		return nil, err
+	}
+
+	fn, ok := vu.state.GetGlobal(IterationFunction).(*lua.LFunction)
+	if !ok {
+		vu.Close()
+		return nil, fmt.Errorf("%s: missing function %s", s.name, IterationFunction)
+	}
+	vu.iteration = fn
+
+	return vu, nil
+}
+
+// libraries are the Lua libraries opened for the scripts, which
+// leaves out those that access the files and the process.
+var libraries = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// newState returns a Lua state with the libraries opened.
+func newState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range libraries {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	// The base library can also load files and modules
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+// shared holds the values shared by all virtual users.
+type shared struct {
+	mu     sync.Mutex
+	values map[string]lua.LValue
+}
+
+func (s *shared) get(key string) lua.LValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.values[key]; ok {
+		return v
+	}
+	return lua.LNil
+}
+
+func (s *shared) set(key string, value lua.LValue) error {
+	switch value.(type) {
+	case lua.LString, lua.LNumber, lua.LBool, *lua.LNilType:
+	default:
+		return fmt.Errorf("shared values must be a string, number, boolean or nil, not %s", value.Type())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value == lua.LNil {
+		delete(s.values, key)
+	} else {
+		s.values[key] = value
+	}
+	return nil
+}
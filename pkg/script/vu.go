@@ -0,0 +1,195 @@
+package script
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// VU is a virtual user running a script, with its own Lua state.
+type VU struct {
+	ctx blaster.VUContext
+	// done is canceled when the blaster stops, or the VU is closed
+	done      context.Context
+	cancel    context.CancelFunc
+	state     *lua.LState
+	iteration *lua.LFunction
+	info      blaster.RequestInfo
+}
+
+// Iterate calls the iteration function of the script.
+func (vu *VU) Iterate(info blaster.RequestInfo) error {
+	vu.info = info
+
+	t := vu.state.NewTable()
+	t.RawSetString("id", lua.LString(info.BlasterID))
+	t.RawSetString("iteration", lua.LNumber(info.Iteration))
+	t.RawSetString("session", lua.LNumber(info.Session))
+
+	err := vu.state.CallByParam(lua.P{
+		Fn:      vu.iteration,
+		NRet:    0,
+		Protect: true,
+	}, t)
+
+	// An iteration interrupted by the blaster stopping hasn't failed
+	if err != nil && vu.done.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Close closes the Lua state.
+func (vu *VU) Close() {
+	vu.cancel()
+	vu.state.Close()
+}
+
+// register adds the API available to scripts.
+func (vu *VU) register(shared *shared) {
+	L := vu.state
+
+	httpTable := L.NewTable()
+	L.SetFuncs(httpTable, map[string]lua.LGFunction{
+		"get":    vu.methodWithoutBody(http.MethodGet),
+		"delete": vu.methodWithoutBody(http.MethodDelete),
+		"post":   vu.methodWithBody(http.MethodPost),
+		"put":    vu.methodWithBody(http.MethodPut),
+		"request": func(L *lua.LState) int {
+			method := strings.ToUpper(L.CheckString(1))
+			return vu.request(L, method, L.CheckString(2), L.OptString(3, ""), L.OptTable(4, nil))
+		},
+	})
+	L.SetGlobal("http", httpTable)
+
+	L.SetGlobal("check", L.NewFunction(func(L *lua.LState) int {
+		passed := lua.LVAsBool(L.Get(1))
+		vu.ctx.Check(L.CheckString(2), passed)
+		L.Push(lua.LBool(passed))
+		return 1
+	}))
+
+	L.SetGlobal("sleep", L.NewFunction(func(L *lua.LState) int {
+		timer := time.NewTimer(time.Duration(float64(L.CheckNumber(1)) * float64(time.Second)))
+		defer timer.Stop()
+
+		// Wake up early if the blaster stops
+		select {
+		case <-timer.C:
+		case <-vu.done.Done():
+		}
+		return 0
+	}))
+
+	sharedTable := L.NewTable()
+	L.SetFuncs(sharedTable, map[string]lua.LGFunction{
+		"get": func(L *lua.LState) int {
+			L.Push(shared.get(L.CheckString(1)))
+			return 1
+		},
+		"set": func(L *lua.LState) int {
+			if err := shared.set(L.CheckString(1), L.Get(2)); err != nil {
+				L.RaiseError("%v", err)
+			}
+			return 0
+		},
+	})
+	L.SetGlobal("shared", sharedTable)
+
+	jsonTable := L.NewTable()
+	L.SetFuncs(jsonTable, map[string]lua.LGFunction{
+		"encode": jsonEncode,
+		"decode": jsonDecode,
+	})
+	L.SetGlobal("json", jsonTable)
+}
+
+func (vu *VU) methodWithoutBody(method string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		return vu.request(L, method, L.CheckString(1), "", L.OptTable(2, nil))
+	}
+}
+
+func (vu *VU) methodWithBody(method string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		return vu.request(L, method, L.CheckString(1), L.OptString(2, ""), L.OptTable(3, nil))
+	}
+}
+
+// request sends a request and pushes the response table.
+func (vu *VU) request(L *lua.LState, method, rawURL, body string, opts *lua.LTable) int {
+	target, err := vu.resolve(rawURL)
+	if err != nil {
+		L.RaiseError("invalid URL %q: %v", rawURL, err)
+	}
+
+	var reader *strings.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	// The request is canceled if the blaster stops
+	var req *http.Request
+	if reader != nil {
+		req, err = http.NewRequestWithContext(vu.done, method, target.String(), reader)
+	} else {
+		req, err = http.NewRequestWithContext(vu.done, method, target.String(), nil)
+	}
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+	req.Header = vu.ctx.Config.Header.Clone()
+
+	info := vu.info
+	info.Name = method + " " + target.Path
+	if target.Path == "" {
+		info.Name += "/"
+	}
+
+	if opts != nil {
+		if headers, ok := opts.RawGetString("headers").(*lua.LTable); ok {
+			headers.ForEach(func(k, v lua.LValue) {
+				req.Header.Set(k.String(), v.String())
+			})
+		}
+		if name, ok := opts.RawGetString("name").(lua.LString); ok {
+			info.Name = string(name)
+		}
+	}
+
+	result, res, resBody := vu.ctx.Do(info, req)
+
+	t := L.NewTable()
+	t.RawSetString("status", lua.LNumber(result.StatusCode))
+	t.RawSetString("body", lua.LString(resBody))
+	t.RawSetString("latency", lua.LNumber(result.Latency.Seconds()))
+
+	headers := L.NewTable()
+	if res != nil {
+		for key := range res.Header {
+			headers.RawSetString(key, lua.LString(res.Header.Get(key)))
+		}
+	}
+	t.RawSetString("headers", headers)
+
+	if result.Err != nil {
+		t.RawSetString("error", lua.LString(result.Err.Error()))
+	}
+
+	L.Push(t)
+	return 1
+}
+
+// resolve returns rawURL relative to the URL of the configuration.
+func (vu *VU) resolve(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return vu.ctx.Config.URL.ResolveReference(u), nil
+}
@@ -0,0 +1,300 @@
+package blastertest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenServer is a fake OAuth2 token endpoint, issuing tokens
+// with the given lifetime that are checked by its target.
+type tokenServer struct {
+	mu       sync.Mutex
+	lifetime time.Duration
+	fail     bool
+	delay    time.Duration
+	fetches  int
+	forms    []map[string]string
+	tokens   map[string]time.Time
+}
+
+func newTokenServer(lifetime time.Duration) *tokenServer {
+	return &tokenServer{lifetime: lifetime, tokens: map[string]time.Time{}}
+}
+
+func (s *tokenServer) setFail(fail bool) {
+	s.mu.Lock()
+	s.fail = fail
+	s.mu.Unlock()
+}
+
+func (s *tokenServer) setDelay(delay time.Duration) {
+	s.mu.Lock()
+	s.delay = delay
+	s.mu.Unlock()
+}
+
+func (s *tokenServer) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, secret, _ := r.BasicAuth()
+	if s.fail || id != "blaster" || secret != "s3cret" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "invalid_client"}`)
+		return
+	}
+
+	r.ParseForm()
+	form := map[string]string{}
+	for k := range r.PostForm {
+		form[k] = r.PostForm.Get(k)
+	}
+	s.forms = append(s.forms, form)
+
+	s.fetches++
+	token := fmt.Sprintf("token-%d", s.fetches)
+	s.tokens[token] = time.Now().Add(s.lifetime)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(s.lifetime / time.Second),
+	})
+}
+
+func (s *tokenServer) target(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiry, ok := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok || time.Now().After(expiry) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+func (s *tokenServer) start(t *testing.T) (tokenURL, targetURL string) {
+	token := httptest.NewServer(http.HandlerFunc(s.token))
+	target := httptest.NewServer(http.HandlerFunc(s.target))
+	t.Cleanup(token.Close)
+	t.Cleanup(target.Close)
+	return token.URL + "/token", target.URL
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	s := newTokenServer(time.Second)
+	tokenURL, targetURL := s.start(t)
+
+	config, err := blaster.NewConfiguration(targetURL, http.MethodGet, 20, 0, nil)
+	require.NoError(t, err)
+	config.Duration = 2500 * time.Millisecond
+	require.NoError(t, config.SetAuth(blaster.AuthConfig{
+		Type:         blaster.AuthOAuth2,
+		TokenURL:     tokenURL,
+		ClientID:     "blaster",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"read", "write"},
+	}))
+
+	runner, err := blaster.NewRunner(config, 2)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	// The token is shared by the blasters, and refreshed before it expires
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 50)
+	require.Equal(t, total.Total, total.Successful)
+	require.GreaterOrEqual(t, s.fetches, 3)
+	require.LessOrEqual(t, s.fetches, 5)
+	require.Equal(t, map[string]string{"grant_type": "client_credentials", "scope": "read write"}, s.forms[0])
+	require.Equal(t, "****", config.Mask("s3cret"))
+}
+
+func TestOAuth2PasswordGrant(t *testing.T) {
+	s := newTokenServer(time.Minute)
+	tokenURL, targetURL := s.start(t)
+
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf(`
+request:
+  url: %s
+auth:
+  type: oauth2
+  grant: password
+  token_url: %s
+  client_id: blaster
+  client_secret: s3cret
+  username: user
+  password: pass
+`, targetURL, tokenURL)))
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 0)
+	require.Equal(t, total.Total, total.Successful)
+	require.Equal(t, 1, s.fetches)
+	require.Equal(t, map[string]string{"grant_type": "password", "username": "user", "password": "pass"}, s.forms[0])
+}
+
+func TestOAuth2TokenFailures(t *testing.T) {
+	s := newTokenServer(time.Second)
+	tokenURL, targetURL := s.start(t)
+	auth := blaster.AuthConfig{
+		Type:         blaster.AuthOAuth2,
+		TokenURL:     tokenURL,
+		ClientID:     "blaster",
+		ClientSecret: "s3cret",
+	}
+
+	// The blast doesn't start without a token
+	s.setFail(true)
+	config, err := blaster.NewConfiguration(targetURL, http.MethodGet, 20, 0, nil)
+	require.NoError(t, err)
+	require.NoError(t, config.SetAuth(auth))
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.Nil(t, result)
+	require.EqualError(t, err, "auth: token endpoint responded 401 Unauthorized: invalid_client")
+
+	// Once the token expires the requests fail without being sent
+	s.setFail(false)
+	config, err = blaster.NewConfiguration(targetURL, http.MethodGet, 20, 0, nil)
+	require.NoError(t, err)
+	config.Duration = 2 * time.Second
+	require.NoError(t, config.SetAuth(auth))
+
+	runner, err = blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		s.setFail(true)
+	}()
+	result, err = runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Errors["auth"], 10)
+	require.Equal(t, total.Total, total.Successful+total.Errors["auth"])
+	require.Empty(t, total.Status[http.StatusUnauthorized])
+}
+
+func TestOAuth2SlowRefresh(t *testing.T) {
+	s := newTokenServer(time.Second)
+	tokenURL, targetURL := s.start(t)
+	hook, err := blaster.NewAuth(blaster.AuthConfig{
+		Type:         blaster.AuthOAuth2,
+		TokenURL:     tokenURL,
+		ClientID:     "blaster",
+		ClientSecret: "s3cret",
+	})
+	require.NoError(t, err)
+	require.NoError(t, hook.(blaster.Preparer).Prepare(context.Background()))
+
+	authorize := func(ctx context.Context) (string, time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+		require.NoError(t, err)
+		start := time.Now()
+		err = hook.BeforeRequest(blaster.RequestInfo{}, req)
+		return req.Header.Get("Authorization"), time.Since(start), err
+	}
+
+	// The token is used while the new one is fetched
+	time.Sleep(850 * time.Millisecond)
+	s.setDelay(time.Second)
+	token, took, err := authorize(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token-1", token)
+	require.Less(t, took, 100*time.Millisecond)
+
+	// A rejected request doesn't wait for the fetch
+	res := &http.Response{
+		Status:     "401 Unauthorized",
+		StatusCode: http.StatusUnauthorized,
+		Request:    &http.Request{Header: http.Header{"Authorization": {"Bearer other"}}},
+	}
+	start := time.Now()
+	require.NoError(t, hook.AfterResponse(blaster.RequestInfo{}, res))
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// Once it expires the requests wait for the new token
+	time.Sleep(250 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = authorize(ctx)
+	require.EqualError(t, err, "auth: context deadline exceeded")
+
+	token, _, err = authorize(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token-2", token)
+	require.Equal(t, 2, s.fetches)
+}
+
+func TestStaticAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer abc" && !(ok && user == "user" && pass == "pass") {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	for _, auth := range []string{
+		"{type: basic, username: user, password: pass}",
+		"{type: bearer, token: abc}",
+	} {
+		t.Run(auth, func(t *testing.T) {
+			config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf("request: {url: %s}\nauth: %s\n", server.URL, auth)))
+			require.NoError(t, err)
+			config.Duration = 300 * time.Millisecond
+
+			runner, err := blaster.NewRunner(config, 1)
+			require.NoError(t, err)
+			result, err := runner.Run(context.Background())
+			require.NoError(t, err)
+
+			total := result.Snapshot.Total()
+			require.Greater(t, total.Total, 0)
+			require.Equal(t, total.Total, total.Successful)
+		})
+	}
+}
+
+func TestAuthErrors(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: http://localhost
+auth:
+  type: oauth2
+  grant: implicit
+  token_url: token
+`))
+	require.EqualError(t, err, strings.Join([]string{
+		"blast.yml:5:3: client_id is required by oauth2 authentication",
+		"blast.yml:6:10: unsupported grant implicit, use client_credentials or password",
+		`blast.yml:7:14: parse "token": invalid URI for request`,
+	}, "\n"))
+
+	_, err = blaster.ParseFile("blast.yml", []byte("request: {url: http://localhost}\nauth: {type: digest}\n"))
+	require.EqualError(t, err, "blast.yml:2:14: unsupported authentication digest, use basic, bearer or oauth2")
+}
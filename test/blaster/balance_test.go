@@ -0,0 +1,136 @@
+package blastertest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// startFleet starts n servers and returns their hosts.
+func startFleet(t *testing.T, n int) []string {
+	hosts := []string{}
+	for i := 0; i < n; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/items" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(server.Close)
+		hosts = append(hosts, strings.TrimPrefix(server.URL, "http://"))
+	}
+	return hosts
+}
+
+func runFleet(t *testing.T, file string, blasters int) blaster.Snapshot {
+	config, err := blaster.ParseFile("blast.yml", []byte(file))
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+
+	runner, err := blaster.NewRunner(config, blasters)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 5)
+	require.Equal(t, total.Total, total.Successful)
+	return result.Snapshot
+}
+
+func TestRoundRobinTargets(t *testing.T) {
+	hosts := startFleet(t, 3)
+	snapshot := runFleet(t, fmt.Sprintf(`
+rate: 20
+request:
+  url: [http://%s/items, http://%s/items, http://%s/items]
+`, hosts[0], hosts[1], hosts[2]), 2)
+
+	// The requests of all blasters are distributed evenly
+	require.Len(t, snapshot.Hosts, 3)
+	total := snapshot.Total().Total
+	for _, host := range hosts {
+		require.InDelta(t, total/3, snapshot.Hosts[host].Total, 1)
+		require.EqualValues(t, snapshot.Hosts[host].Total, snapshot.Hosts[host].Latency.Count)
+	}
+}
+
+func TestHashTargets(t *testing.T) {
+	hosts := startFleet(t, 3)
+	dir := t.TempDir()
+	writeFile(t, dir, "hosts.txt", "# the fleet\n"+strings.Join(hosts, "\n")+"\n\n")
+
+	// All requests of a blaster are sent to the same host
+	file := writeFile(t, dir, "blast.yml", `
+rate: 20
+request:
+  url: http://localhost/items
+  hosts_file: hosts.txt
+  strategy: hash
+`)
+	config, err := blaster.LoadFile(file)
+	require.NoError(t, err)
+	require.Len(t, config.Targets().URLs, 3)
+	require.Equal(t, "3 targets, hash by {{.BlasterID}}", config.Targets().String())
+
+	config.Duration = 500 * time.Millisecond
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+	require.Len(t, result.Snapshot.Hosts, 1)
+
+	// The targets are kept when sent to another process
+	decoded := roundTrip(t, config)
+	require.Equal(t, config.Targets(), decoded.Targets())
+
+	// Requests with different keys are spread over the hosts
+	snapshot := runFleet(t, fmt.Sprintf(`
+rate: 20
+request:
+  url: [http://%s/items, http://%s/items, http://%s/items]
+  strategy: hash
+  hash_key: "{{.Iteration}}"
+`, hosts[0], hosts[1], hosts[2]), 1)
+	require.Greater(t, len(snapshot.Hosts), 1)
+}
+
+func TestRandomTargets(t *testing.T) {
+	hosts := startFleet(t, 2)
+	snapshot := runFleet(t, fmt.Sprintf(`
+rate: 40
+request:
+  url: [http://%s/items, http://%s/items]
+  strategy: random
+`, hosts[0], hosts[1]), 1)
+	require.Len(t, snapshot.Hosts, 2)
+}
+
+func TestTargetErrors(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte(`
+request:
+  url:
+    - http://localhost/items
+    - "unix:///var/run/app.sock"
+    - items
+  strategy: sticky
+`))
+	require.EqualError(t, err, strings.Join([]string{
+		"blast.yml:5:7: a Unix domain socket can't be one of several targets",
+		`blast.yml:6:7: parse "items": invalid URI for request`,
+		"blast.yml:7:13: unsupported strategy sticky, use round_robin, random or hash",
+	}, "\n"))
+
+	_, err = blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: [http://a/items, http://b/items]
+  hosts_file: hosts.txt
+`))
+	require.EqualError(t, err, "blast.yml:4:15: request.hosts_file requires a single request.url")
+}
@@ -0,0 +1,131 @@
+package blastertest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// limitedHandler responds with 503 Service Unavailable to requests
+// arriving sooner than minInterval after the previous one, i.e.
+// when the rate is above 1/minInterval.
+type limitedHandler struct {
+	mu          sync.Mutex
+	last        time.Time
+	minInterval time.Duration
+}
+
+func (h *limitedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.last) < h.minInterval {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	h.last = now
+}
+
+func TestFindMaxBinarySearch(t *testing.T) {
+	server := httptest.NewServer(&limitedHandler{minInterval: 20 * time.Millisecond})
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 0, 0, http.Header{})
+	require.NoError(t, err)
+
+	steps := 0
+	search := blaster.NewCapacitySearch()
+	search.MaxRate = 64
+	search.StepDuration = 500 * time.Millisecond
+	search.MaxErrorRate = 0.05
+	search.OnStep = func(blaster.CapacityStep) { steps++ }
+	result, err := blaster.FindMax(config, search)
+	require.NoError(t, err)
+
+	// The server accepts at most 50 req/s
+	require.True(t, result.MaxRate >= 40 && result.MaxRate <= 50, "max rate %d", result.MaxRate)
+	require.Equal(t, float64(result.MaxRate), result.MaxTotalRate)
+	require.Len(t, result.Steps, steps)
+	require.Equal(t, 32, result.Steps[0].Rate)
+}
+
+func TestFindMaxSteps(t *testing.T) {
+	server := httptest.NewServer(&limitedHandler{minInterval: 40 * time.Millisecond})
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 0, 0, http.Header{})
+	require.NoError(t, err)
+
+	search := blaster.NewCapacitySearch()
+	search.MinRate = 10
+	search.Step = 10
+	search.StepDuration = 500 * time.Millisecond
+	result, err := blaster.FindMax(config, search)
+	require.NoError(t, err)
+
+	// The server accepts at most 25 req/s
+	require.Equal(t, 20, result.MaxRate)
+	require.Len(t, result.Steps, 3)
+	require.False(t, result.Steps[2].Passed)
+	require.Contains(t, result.Steps[2].Reason, "error rate")
+}
+
+func TestFindMaxLatency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 0, 0, http.Header{})
+	require.NoError(t, err)
+
+	search := blaster.NewCapacitySearch()
+	search.MinRate = 10
+	search.MaxRate = 10
+	search.StepDuration = 500 * time.Millisecond
+	search.MaxLatency = 10 * time.Millisecond
+	result, err := blaster.FindMax(config, search)
+	require.NoError(t, err)
+	require.Equal(t, 0, result.MaxRate)
+	require.Contains(t, result.Steps[0].Reason, "latency")
+}
+
+func TestFindMaxZeroErrorRate(t *testing.T) {
+	// The first of about 100 requests fails, which is below the default max error rate
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { w.WriteHeader(http.StatusInternalServerError) })
+	}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 0, 0, http.Header{})
+	require.NoError(t, err)
+
+	search := blaster.NewCapacitySearch()
+	search.Blasters = 2
+	search.MinRate = 100
+	search.StepDuration = 600 * time.Millisecond
+	search.MaxErrorRate = 0
+	search.MinAchieved = 0
+	result, err := blaster.FindMax(config, search)
+	require.NoError(t, err)
+	require.Equal(t, 0, result.MaxRate)
+	require.Contains(t, result.Steps[0].Reason, "error rate")
+
+	search.MaxErrorRate = 1.5
+	_, err = blaster.FindMax(config, search)
+	require.EqualError(t, err, "max error rate must be in the range 0-1")
+}
+
+func TestNewCapacitySearch(t *testing.T) {
+	search := blaster.NewCapacitySearch()
+	require.Equal(t, blaster.DefaultMaxErrorRate, search.MaxErrorRate)
+	require.Equal(t, blaster.DefaultMinAchieved, search.MinAchieved)
+	require.Equal(t, blaster.DefaultLatencyQuantile, search.LatencyQuantile)
+	require.Equal(t, blaster.DefaultStepDuration, search.StepDuration)
+}
@@ -0,0 +1,138 @@
+package blastertest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// hostServer records the host and request URI of the requests.
+type hostServer struct {
+	mu    sync.Mutex
+	hosts map[string]int
+}
+
+func (s *hostServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hosts[r.Host+r.RequestURI]++
+}
+
+// keys returns the recorded hosts and request URIs, sorted.
+func (s *hostServer) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for k := range s.hosts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func runDial(t *testing.T, config *blaster.Configuration) {
+	config.Duration = 300 * time.Millisecond
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 0)
+	require.Equal(t, total.Total, total.Successful)
+}
+
+func TestUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	s := &hostServer{hosts: map[string]int{}}
+	server := &http.Server{Handler: s}
+	go server.Serve(l)
+	defer server.Close()
+
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf("request: {url: \"unix://%s:/health?verbose=1\"}\n", socket)))
+	require.NoError(t, err)
+	require.Equal(t, socket, config.Socket())
+	require.Equal(t, "GET /health", config.Name)
+	runDial(t, config)
+	require.Equal(t, []string{"localhost/health?verbose=1"}, s.keys())
+
+	// The socket is kept when sent to another process
+	decoded := roundTrip(t, config)
+	require.Equal(t, "unix://"+socket+":/health?verbose=1", decoded.Target())
+
+	// The HTTP path defaults to /
+	config, err = blaster.NewConfiguration("unix://"+socket, http.MethodGet, 0, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, "/", config.URL.Path)
+}
+
+func TestResolve(t *testing.T) {
+	s := &hostServer{hosts: map[string]int{}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// The Host header is still that of the URL
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf(`
+request:
+  url: http://api.example.com:%s/items
+  resolve: ["api.example.com:%s:127.0.0.1"]
+`, port, port)))
+	require.NoError(t, err)
+	runDial(t, config)
+
+	config, err = blaster.NewConfiguration("http://backend.example.com/items", http.MethodGet, 0, 0, nil)
+	require.NoError(t, err)
+	hostport, address, err := blaster.ParseConnectTo("backend.example.com:80:127.0.0.1:" + port)
+	require.NoError(t, err)
+	require.NoError(t, config.Resolve(hostport, address))
+	runDial(t, config)
+
+	require.Equal(t, []string{"api.example.com:" + port + "/items", "backend.example.com/items"}, s.keys())
+}
+
+func TestParseResolve(t *testing.T) {
+	tests := []struct {
+		rule     string
+		parse    func(string) (string, string, error)
+		hostport string
+		address  string
+	}{
+		{"example.com:443:10.0.0.1", blaster.ParseResolve, "example.com:443", "10.0.0.1:443"},
+		{"example.com:443:[::1]", blaster.ParseResolve, "example.com:443", "[::1]:443"},
+		{"example.com:443:backend:8443", blaster.ParseConnectTo, "example.com:443", "backend:8443"},
+		{"example.com:443::8443", blaster.ParseConnectTo, "example.com:443", "example.com:8443"},
+		{"example.com:443:backend:", blaster.ParseConnectTo, "example.com:443", "backend:443"},
+	}
+	for _, tt := range tests {
+		hostport, address, err := tt.parse(tt.rule)
+		require.NoError(t, err, tt.rule)
+		require.Equal(t, tt.hostport, hostport, tt.rule)
+		require.Equal(t, tt.address, address, tt.rule)
+	}
+
+	_, err := blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: unix://
+  resolve: [example.com:443]
+  connect_to: [example.com]
+`))
+	require.EqualError(t, err, strings.Join([]string{
+		"blast.yml:3:8: the socket is missing, use unix:///path/to.sock:/path",
+		"blast.yml:4:13: invalid resolve example.com:443, use host:port:address",
+		"blast.yml:5:16: invalid connect-to example.com, use host:port:connect-host:connect-port",
+	}, "\n"))
+}
@@ -0,0 +1,128 @@
+package blastertest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFile(t *testing.T) {
+	config, err := blaster.LoadFile("testdata/blast.yml")
+	require.NoError(t, err)
+	require.Equal(t, 10, config.Rate)
+	require.Equal(t, 5*time.Second, config.Duration)
+	require.Equal(t, "http://localhost", config.URL.String())
+	require.Equal(t, http.MethodGet, config.HTTPMethod)
+	require.Equal(t, "mytoken", config.Header.Get("Authentication"))
+	require.True(t, config.Valid())
+}
+
+func TestLoadDocumentedFile(t *testing.T) {
+	config, err := blaster.LoadFile("../../docs/blast.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "create-tasks", config.Name)
+	require.Len(t, config.Metrics.Sinks, 2)
+}
+
+func TestParseFileNestedBody(t *testing.T) {
+	config, err := blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: http://localhost
+  method: post
+  body:
+    tasks:
+      - name: clean
+`))
+	require.NoError(t, err)
+
+	req, err := config.BuildRequest()
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"tasks": [{"name": "clean"}]}`, string(b))
+}
+
+func TestParseFileErrors(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte(`rate: 1000
+duraton: 10
+duration: abc
+request:
+  url: localhost
+  methd: GET
+  headers:
+    - value: x
+metrics:
+  sinks: statsd
+`))
+	require.Error(t, err)
+
+	verr, ok := err.(*blaster.ValidationError)
+	require.True(t, ok, "expected a *ValidationError, got %T", err)
+
+	type position struct {
+		field        string
+		line, column int
+	}
+	positions := []position{}
+	for _, e := range verr.Errors {
+		require.Equal(t, "blast.yml", e.File)
+		positions = append(positions, position{e.Field, e.Line, e.Column})
+	}
+	require.Equal(t, []position{
+		{"rate", 1, 7},
+		{"duraton", 2, 1},
+		{"duration", 3, 11},
+		{"request.url", 5, 8},
+		{"request.methd", 6, 3},
+		{"request.headers[0].name", 8, 7},
+		{"metrics.sinks", 10, 10},
+	}, positions)
+
+	require.Contains(t, err.Error(), "blast.yml:2:1: unknown field duraton, did you mean duration?")
+}
+
+func TestParseFileSyntaxError(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte("rate: [1\nrequest:\n"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "blast.yml:")
+}
+
+func TestParseFileMissingURL(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte("rate: 5\n"))
+	require.EqualError(t, err, "blast.yml: request.url is required")
+
+	_, err = blaster.ParseFile("blast.yml", []byte("request:\n  name: x\n"))
+	require.EqualError(t, err, "blast.yml:2:3: request.url is required")
+}
+
+func TestNewConfigurationAllErrors(t *testing.T) {
+	_, err := blaster.NewConfiguration("localhost", "lol", -1, -1, nil)
+	require.Error(t, err)
+
+	verr, ok := err.(*blaster.ValidationError)
+	require.True(t, ok)
+
+	fields := []string{}
+	for _, e := range verr.Errors {
+		fields = append(fields, e.Field)
+	}
+	require.Equal(t, []string{"url", "method", "rate", "duration"}, fields)
+}
+
+func TestSchemaIsValidJSON(t *testing.T) {
+	b, err := ioutil.ReadFile("../../docs/blast.schema.json")
+	require.NoError(t, err)
+
+	var schema struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(b, &schema))
+	for _, key := range []string{"rate", "duration", "script", "request", "metrics"} {
+		require.Contains(t, schema.Properties, key)
+	}
+}
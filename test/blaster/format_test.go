@@ -0,0 +1,106 @@
+package blastertest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFileFormats(t *testing.T) {
+	for _, file := range []string{"blast.yml", "blast.json", "blast.toml"} {
+		t.Run(file, func(t *testing.T) {
+			config, err := blaster.LoadFile(filepath.Join("testdata", file))
+			require.NoError(t, err)
+			require.Equal(t, 10, config.Rate)
+			require.Equal(t, 5*time.Second, config.Duration)
+			require.Equal(t, "http://localhost", config.URL.String())
+			require.Equal(t, http.MethodGet, config.HTTPMethod)
+			require.Equal(t, "mytoken", config.Header.Get("Authentication"))
+		})
+	}
+}
+
+func TestParseFileFormatErrors(t *testing.T) {
+	_, err := blaster.ParseFile("blast.json", []byte("{\n  \"rate\": 10,\n}"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "blast.json:3:")
+
+	_, err = blaster.ParseFile("blast.json", []byte(`{"rate": 10, "duraton": 5, "request": {"url": "http://localhost"}}`))
+	require.EqualError(t, err, "blast.json:1:14: unknown field duraton, did you mean duration?")
+
+	_, err = blaster.ParseFile("blast.toml", []byte("rate = 10\nrequest = [\n"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "blast.toml:")
+
+	_, err = blaster.ParseFile("blast.toml", []byte("rate = \"ten\"\n[request]\nurl = \"http://localhost\"\n"))
+	require.EqualError(t, err, `blast.toml: rate must be an integer, not "ten"`)
+}
+
+func TestRequestBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     string
+		body        string
+		contentType string
+	}{
+		{"json object", "body: {name: clean}", `{"name":"clean"}`, "application/json"},
+		{"json array", "body: [1, 2]", `[1,2]`, "application/json"},
+		{"json string", "body: hello", `"hello"`, "application/json"},
+		{"raw", "body_raw: hello world", "hello world", "text/plain; charset=utf-8"},
+		{"file", "body_file: body.xml", `<item id="1"/>`, "text/xml; charset=utf-8"},
+		{"json value_from_file", "body: {value_from_file: body.xml}", `{"value_from_file":"body.xml"}`, "application/json"},
+		{"base64", "body_base64: AAEC", "\x00\x01\x02", "application/octet-stream"},
+		{"form", "body_form: {q: go blast, tag: [a, b], n: 1}", "n=1&q=go+blast&tag=a&tag=b", "application/x-www-form-urlencoded"},
+		{"explicit content type", "body_raw: <a/>\n  headers: [{name: content-type, value: application/xml}]", "<a/>", "application/xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := blaster.ParseFile("testdata/blast.yml", []byte(`
+request:
+  url: http://localhost
+  method: post
+  `+tt.request+"\n"))
+			require.NoError(t, err)
+			require.Equal(t, tt.contentType, config.Header.Get("Content-Type"))
+
+			req, err := config.BuildRequest()
+			require.NoError(t, err)
+			b, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.body, string(b))
+		})
+	}
+}
+
+func TestRequestBodyErrors(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: http://localhost
+  body: {a: 1}
+  body_raw: a
+`))
+	require.EqualError(t, err, "blast.yml:5:13: only one body may be given, not body and body_raw")
+
+	_, err = blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: http://localhost
+  body_base64: "not base64!"
+`))
+	require.Error(t, err)
+	verr := err.(*blaster.ValidationError)
+	require.Len(t, verr.Errors, 1)
+	require.Equal(t, "request.body_base64", verr.Errors[0].Field)
+	require.Equal(t, 4, verr.Errors[0].Line)
+
+	_, err = blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: http://localhost
+  body_form: {a: {b: c}}
+`))
+	require.EqualError(t, err, "blast.yml:4:18: request.body_form.a must be a string, number, boolean or a list of those")
+}
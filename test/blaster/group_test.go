@@ -0,0 +1,63 @@
+package blastertest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupStartTwice(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 20, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+
+	group, err := blaster.NewGroup(config, 2)
+	require.NoError(t, err)
+	group.Start()
+	group.Start()
+
+	done := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the group is still running")
+	}
+	require.InDelta(t, 20, group.Snapshot().Total().Total, 4)
+}
+
+// failingScenario fails to create its virtual users.
+type failingScenario struct{}
+
+func (failingScenario) NewVU(ctx blaster.VUContext) (blaster.VU, error) {
+	return nil, fmt.Errorf("no users today")
+}
+
+func TestGroupDoneEarly(t *testing.T) {
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 20, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = 5 * time.Second
+	config.Scenario = failingScenario{}
+
+	group, err := blaster.NewGroup(config, 2)
+	require.NoError(t, err)
+
+	// The group is done when its blasters are, not when its duration has passed
+	start := time.Now()
+	group.Start()
+	group.Wait()
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	require.Equal(t, 2, group.Snapshot().Requests[blaster.ScenarioRequestName].Errors["scenario"])
+	require.EqualError(t, group.SetBlasters(3), "the blast is done")
+}
@@ -0,0 +1,398 @@
+package blastertest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const greetProto = `
+syntax = "proto3";
+
+package greet.v1;
+
+import "types.proto";
+
+service Greeter {
+  // SayHello greets the name, unless grumpy
+  rpc SayHello (HelloRequest) returns (HelloReply);
+  rpc Countdown (CountdownRequest) returns (stream HelloReply) {}
+}
+
+message HelloRequest {
+  string name = 1;
+  Mood mood = 2;
+  repeated int32 numbers = 3;
+  map<string, string> tags = 4;
+}
+
+message HelloReply {
+  string message = 1;
+}
+
+message CountdownRequest {
+  int32 from = 1;
+}
+`
+
+const typesProto = `
+syntax = "proto3";
+package greet.v1;
+
+enum Mood {
+  MOOD_UNSPECIFIED = 0;
+  HAPPY = 1;
+  GRUMPY = 2;
+}
+`
+
+var (
+	greeterOnce    sync.Once
+	greeterService protoreflect.ServiceDescriptor
+)
+
+// greeter returns the Greeter service, compiled from greetProto and
+// typesProto and registered for server reflection the first time.
+func greeter(t *testing.T) protoreflect.ServiceDescriptor {
+	greeterOnce.Do(func() {
+		compiler := protocompile.Compiler{
+			Resolver: &protocompile.SourceResolver{
+				Accessor: protocompile.SourceAccessorFromMap(map[string]string{"greet.proto": greetProto, "types.proto": typesProto}),
+			},
+		}
+		files, err := compiler.Compile(context.Background(), "types.proto", "greet.proto")
+		require.NoError(t, err)
+		for _, f := range files {
+			require.NoError(t, protoregistry.GlobalFiles.RegisterFile(f))
+		}
+		greeterService = files[1].Services().ByName("Greeter")
+	})
+	require.NotNil(t, greeterService)
+	return greeterService
+}
+
+// greeterServer is a gRPC server implementing the Greeter
+// service and, if withReflection is true, server reflection.
+type greeterServer struct {
+	URL string
+
+	mu       sync.Mutex
+	requests []map[string]interface{}
+	metadata []metadata.MD
+}
+
+func newGreeterServer(t *testing.T, withReflection bool) *greeterServer {
+	service := greeter(t)
+	s := &greeterServer{}
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: string(service.FullName()),
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "SayHello", Handler: s.sayHello(service.Methods().ByName("SayHello"))},
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Countdown", Handler: s.countdown(service.Methods().ByName("Countdown")), ServerStreams: true},
+		},
+		Metadata: "greet.proto",
+	}, s)
+	if withReflection {
+		reflection.Register(server)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+	s.URL = "http://" + l.Addr().String()
+	return s
+}
+
+// receive decodes the request of a call and records it.
+func (s *greeterServer) receive(ctx context.Context, m protoreflect.MethodDescriptor, decode func(interface{}) error) (map[string]interface{}, error) {
+	in := dynamicpb.NewMessage(m.Input())
+	if err := decode(in); err != nil {
+		return nil, err
+	}
+	b, err := protojson.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]interface{}{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil, err
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.metadata = append(s.metadata, md)
+	s.mu.Unlock()
+	return req, nil
+}
+
+func reply(m protoreflect.MethodDescriptor, message string) *dynamicpb.Message {
+	out := dynamicpb.NewMessage(m.Output())
+	out.Set(m.Output().Fields().ByName("message"), protoreflect.ValueOfString(message))
+	return out
+}
+
+func (s *greeterServer) sayHello(m protoreflect.MethodDescriptor) grpc.MethodHandler {
+	return func(_ interface{}, ctx context.Context, decode func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		req, err := s.receive(ctx, m, decode)
+		if err != nil {
+			return nil, err
+		}
+		if req["mood"] == "GRUMPY" {
+			return nil, status.Error(codes.PermissionDenied, "go away")
+		}
+		return reply(m, fmt.Sprintf("Hello %s", req["name"])), nil
+	}
+}
+
+func (s *greeterServer) countdown(m protoreflect.MethodDescriptor) grpc.StreamHandler {
+	return func(_ interface{}, stream grpc.ServerStream) error {
+		req, err := s.receive(stream.Context(), m, stream.RecvMsg)
+		if err != nil {
+			return err
+		}
+		from, _ := req["from"].(float64)
+		for i := int(from); i > 0; i-- {
+			if err := stream.SendMsg(reply(m, fmt.Sprint(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func runGRPC(t *testing.T, config *blaster.Configuration, blasters int) blaster.Snapshot {
+	config.Duration = 500 * time.Millisecond
+	runner, err := blaster.NewRunner(config, blasters)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+	return result.Snapshot
+}
+
+func TestGRPCProtoFiles(t *testing.T) {
+	server := newGreeterServer(t, false)
+	dir := t.TempDir()
+	writeFile(t, dir, "greet.proto", greetProto)
+	writeFile(t, dir, "types.proto", typesProto)
+	writeFile(t, dir, "users.csv", "user\nalice\nbob\n")
+	file := writeFile(t, dir, "blast.yml", fmt.Sprintf(`
+rate: 20
+request:
+  url: %s
+  headers:
+    - {name: X-Tenant, value: acme}
+auth:
+  type: bearer
+  token: s3cret
+grpc:
+  method: greet.v1.Greeter/SayHello
+  proto_files: [greet.proto]
+  message:
+    name: '{{.Row.user}}'
+    mood: HAPPY
+    numbers: [1, '{{.Iteration}}']
+    tags:
+      blaster: '{{.BlasterID}}'
+  data: users.csv
+`, server.URL))
+
+	config, err := blaster.LoadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, "GRPC /greet.v1.Greeter/SayHello", config.Name)
+	require.Equal(t, "greet.v1.Greeter/SayHello defined by "+dir+"/greet.proto", config.GRPC().String())
+
+	snapshot := runGRPC(t, config, 2)
+	calls := snapshot.Requests["GRPC /greet.v1.Greeter/SayHello"]
+	require.Greater(t, calls.Total, 10)
+	require.Equal(t, calls.Total, calls.Successful)
+	require.Equal(t, map[string]int{"OK": calls.Total}, calls.GRPCStatus)
+	require.Empty(t, calls.Status)
+	require.Equal(t, map[string]int{"HTTP/2.0": calls.Total}, calls.Protocols)
+	require.EqualValues(t, calls.Total, calls.Latency.Count)
+
+	// The message is encoded from the templates, and
+	// the headers are sent as metadata
+	server.mu.Lock()
+	var names []string
+	for i, req := range server.requests {
+		names = append(names, req["name"].(string))
+		require.Equal(t, "HAPPY", req["mood"])
+		require.Equal(t, float64(1), req["numbers"].([]interface{})[0])
+		require.NotEmpty(t, req["tags"].(map[string]interface{})["blaster"])
+		require.Equal(t, []string{"Bearer s3cret"}, server.metadata[i].Get("authorization"))
+		require.Equal(t, []string{"acme"}, server.metadata[i].Get("x-tenant"))
+	}
+	server.mu.Unlock()
+	names = unique(names)
+	sort.Strings(names)
+	require.Equal(t, []string{"alice", "bob"}, names)
+
+	// The configuration is kept, with the files, when sent to another process
+	config.SendSecrets = true
+	decoded := roundTrip(t, config)
+	expected, actual := *config.GRPC(), *decoded.GRPC()
+	expected.DataFile = ""
+	expected.Message, actual.Message = nil, nil
+	require.Equal(t, expected, actual)
+	message, err := json.Marshal(decoded.GRPC().Message)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"name":"{{.Row.user}}","mood":"HAPPY","numbers":[1,"{{.Iteration}}"],"tags":{"blaster":"{{.BlasterID}}"}}`, string(message))
+	require.Equal(t, greetProto, decoded.GRPC().Sources[dir+"/greet.proto"])
+	require.Equal(t, typesProto, decoded.GRPC().Sources["types.proto"])
+}
+
+func TestGRPCStatus(t *testing.T) {
+	server := newGreeterServer(t, false)
+	dir := t.TempDir()
+	writeFile(t, dir, "greet.proto", greetProto)
+	writeFile(t, dir, "types.proto", typesProto)
+
+	load := func(method, message string) *blaster.Configuration {
+		file := writeFile(t, dir, "blast.yml", fmt.Sprintf(`
+rate: 20
+request:
+  url: %s
+grpc:
+  method: %s
+  proto_files: [greet.proto]
+  message: %s
+`, server.URL, method, message))
+		config, err := blaster.LoadFile(file)
+		require.NoError(t, err)
+		return config
+	}
+
+	// The status codes are counted instead of the HTTP status
+	snapshot := runGRPC(t, load("greet.v1.Greeter/SayHello", "{name: oscar, mood: GRUMPY}"), 1)
+	calls := snapshot.Requests["GRPC /greet.v1.Greeter/SayHello"]
+	require.Greater(t, calls.Total, 4)
+	require.Equal(t, 0, calls.Successful)
+	require.Equal(t, map[string]int{"PERMISSION_DENIED": calls.Total}, calls.GRPCStatus)
+	require.Equal(t, map[string]int{"grpc": calls.Total}, calls.Errors)
+	require.Empty(t, calls.Status)
+
+	// All messages of a server stream are read
+	snapshot = runGRPC(t, load("/greet.v1.Greeter/Countdown", "{from: 3}"), 1)
+	calls = snapshot.Requests["GRPC /greet.v1.Greeter/Countdown"]
+	require.Greater(t, calls.Total, 4)
+	require.Equal(t, map[string]int{"OK": calls.Total}, calls.GRPCStatus)
+
+	// Methods the server doesn't implement
+	writeFile(t, dir, "greet.proto", strings.Replace(greetProto, "rpc Countdown", "rpc Goodbye (HelloRequest) returns (HelloReply);\n  rpc Countdown", 1))
+	snapshot = runGRPC(t, load("greet.v1.Greeter.Goodbye", "{}"), 1)
+	calls = snapshot.Requests["GRPC /greet.v1.Greeter.Goodbye"]
+	require.Greater(t, calls.Total, 4)
+	require.Equal(t, map[string]int{"UNIMPLEMENTED": calls.Total}, calls.GRPCStatus)
+}
+
+func TestGRPCReflection(t *testing.T) {
+	server := newGreeterServer(t, true)
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf(`
+rate: 20
+request:
+  url: %s
+grpc:
+  method: greet.v1.Greeter/SayHello
+  message: {name: world, numbers: [1, 2], tags: {a: b}}
+`, server.URL)))
+	require.NoError(t, err)
+	require.Equal(t, "greet.v1.Greeter/SayHello using server reflection", config.GRPC().String())
+
+	snapshot := runGRPC(t, config, 1)
+	calls := snapshot.Requests["GRPC /greet.v1.Greeter/SayHello"]
+	require.Greater(t, calls.Total, 4)
+	require.Equal(t, map[string]int{"OK": calls.Total}, calls.GRPCStatus)
+
+	// The service is fetched with its dependency
+	server.mu.Lock()
+	require.Equal(t, map[string]interface{}{"a": "b"}, server.requests[0]["tags"])
+	server.mu.Unlock()
+
+	// The blast fails if the service can't be resolved
+	config, err = blaster.ParseFile("blast.yml", []byte(fmt.Sprintf("request: {url: %s}\ngrpc: {method: greet.v1.Unknown/SayHello}\n", server.URL)))
+	require.NoError(t, err)
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	_, err = runner.Run(context.Background())
+	require.EqualError(t, err, "grpc greet.v1.Unknown/SayHello: reflection: Service not found: greet.v1.Unknown")
+
+	withoutReflection := newGreeterServer(t, false)
+	config, err = blaster.ParseFile("blast.yml", []byte(fmt.Sprintf("request: {url: %s}\ngrpc: {method: greet.v1.Greeter/SayHello}\n", withoutReflection.URL)))
+	require.NoError(t, err)
+	runner, err = blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	_, err = runner.Run(context.Background())
+	require.EqualError(t, err, "grpc greet.v1.Greeter/SayHello: reflection: rpc error: code = Unimplemented desc = unknown service grpc.reflection.v1alpha.ServerReflection")
+}
+
+func TestGRPCErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "greet.proto", greetProto)
+	writeFile(t, dir, "types.proto", typesProto)
+	parse := func(grpc string) error {
+		file := writeFile(t, dir, "blast.yml", "request:\n  url: http://localhost\n"+grpc)
+		_, err := blaster.LoadFile(file)
+		return err
+	}
+
+	err := parse(`grpc:
+  proto_files: [greet.proto]
+  message: {name: '{{.Missing'}
+`)
+	require.EqualError(t, err, strings.Join([]string{
+		dir + "/blast.yml:4:3: method is required, e.g. package.Service/Method",
+		dir + "/blast.yml:5:19: template: :1: unclosed action",
+	}, "\n"))
+
+	err = parse(`grpc:
+  method: greet.v1.Greeter/Goodbye
+  proto_files: [greet.proto]
+`)
+	require.EqualError(t, err, dir+"/blast.yml:4:11: unknown method Goodbye of greet.v1.Greeter")
+
+	err = parse(`grpc:
+  method: greet.v1.Greeter/SayHello
+  proto_files: [greet.proto]
+  message: {name: world, mood: SAD}
+`)
+	// The protojson errors are deliberately unstable
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), dir+"/blast.yml:6:12: proto:"), err.Error())
+	require.Contains(t, err.Error(), `invalid value for enum field mood: "SAD"`)
+
+	err = parse(`grpc:
+  method: greet.v1.Greeter/SayHello
+  proto_files: [missing.proto]
+`)
+	require.EqualError(t, err, dir+"/blast.yml:5:16: open "+dir+"/missing.proto: no such file or directory")
+
+	err = parse(`websocket: {messages: [hello]}
+grpc: {method: greet.v1.Greeter/SayHello}
+`)
+	require.EqualError(t, err, dir+"/blast.yml:4:7: only one of script, websocket and grpc may be given")
+}
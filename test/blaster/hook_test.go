@@ -0,0 +1,118 @@
+package blastertest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the iteration so that the response hook can check it
+		w.Header().Set("X-Iteration", r.Header.Get("X-Iteration"))
+		w.Header().Set("X-Trace", r.Header.Get("X-Trace"))
+	}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 20, 0, http.Header{"X-Static": {"yes"}})
+	require.NoError(t, err)
+	config.Duration = time.Second
+
+	var mu sync.Mutex
+	order := []string{}
+	iterations := map[string][]int{}
+
+	config.Use(
+		blaster.HookFuncs{
+			Before: func(info blaster.RequestInfo, req *http.Request) error {
+				req.Header.Set("X-Iteration", strconv.Itoa(info.Iteration))
+				mu.Lock()
+				order = append(order, "first")
+				iterations[info.BlasterID] = append(iterations[info.BlasterID], info.Iteration)
+				mu.Unlock()
+				return nil
+			},
+		},
+		blaster.HookFuncs{
+			Before: func(info blaster.RequestInfo, req *http.Request) error {
+				req.Header.Set("X-Trace", info.BlasterID)
+				mu.Lock()
+				order = append(order, "second")
+				mu.Unlock()
+				return nil
+			},
+			After: func(info blaster.RequestInfo, res *http.Response) error {
+				if res.Header.Get("X-Trace") != info.BlasterID {
+					return fmt.Errorf("missing trace header")
+				}
+				// Fail every fifth request
+				if res.Header.Get("X-Iteration") != strconv.Itoa(info.Iteration) || info.Iteration%5 == 4 {
+					return fmt.Errorf("iteration %d failed", info.Iteration)
+				}
+				return nil
+			},
+		},
+	)
+
+	runner, err := blaster.NewRunner(config, 2)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 20)
+	require.Equal(t, total.Total, total.Status[http.StatusOK])
+	require.Equal(t, total.Total-total.Successful, total.Errors["hook"])
+	require.InDelta(t, total.Total/5, total.Errors["hook"], 2)
+
+	// Each blaster counts its own iterations
+	require.Len(t, iterations, 2)
+	for _, its := range iterations {
+		for i, it := range its {
+			require.Equal(t, i, it)
+		}
+	}
+
+	for i := 0; i < len(order); i += 2 {
+		require.Equal(t, []string{"first", "second"}, order[i:i+2])
+	}
+
+	// The configuration itself is not modified by the hooks
+	require.Equal(t, http.Header{"X-Static": {"yes"}}, config.Header)
+}
+
+func TestHookFailsBeforeSend(t *testing.T) {
+	sent := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+	}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 20, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+	config.Use(blaster.HookFuncs{
+		Before: func(info blaster.RequestInfo, req *http.Request) error {
+			return fmt.Errorf("token expired")
+		},
+	})
+
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Equal(t, 0, sent)
+	require.Greater(t, total.Total, 0)
+	require.Equal(t, total.Total, total.Errors["hook"])
+	require.Empty(t, total.Status)
+}
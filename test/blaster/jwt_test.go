@@ -0,0 +1,244 @@
+package blastertest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// jwtServer accepts requests with a JWT verified by verify,
+// and records the tokens and their claims.
+type jwtServer struct {
+	mu     sync.Mutex
+	tokens map[string]bool
+	claims []map[string]interface{}
+}
+
+func (s *jwtServer) start(t *testing.T, header string, verify func(signed, sig []byte) bool) string {
+	s.tokens = map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get(header), "Bearer ")
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims map[string]interface{}
+		if !verify([]byte(parts[0]+"."+parts[1]), sig) || json.Unmarshal(payload, &claims) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		s.mu.Lock()
+		s.tokens[token] = true
+		s.claims = append(s.claims, claims)
+		s.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func runJWT(t *testing.T, file string, blasters int) *blaster.Result {
+	config, err := blaster.LoadFile(file)
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+
+	runner, err := blaster.NewRunner(config, blasters)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 2)
+	require.Equal(t, total.Total, total.Successful)
+	return result
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestJWTHS256(t *testing.T) {
+	s := &jwtServer{}
+	url := s.start(t, "X-Token", func(signed, sig []byte) bool {
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	})
+
+	dir := t.TempDir()
+	writeFile(t, dir, "users.csv", "id,role\nalice,admin\nbob,user\n")
+	file := writeFile(t, dir, "blast.yml", fmt.Sprintf(`
+request:
+  url: %s
+jwt:
+  algorithm: HS256
+  key: s3cret
+  header: X-Token
+  expires_in: 300
+  data: users.csv
+  claims:
+    sub: "{{.Row.id}}"
+    iss: goblast
+    level: 3
+    context: {role: "{{.Row.role}}", blaster: "{{.BlasterID}}"}
+`, url))
+
+	result := runJWT(t, file, 1)
+
+	// Each request gets a new token, using the next row
+	require.Len(t, s.claims, result.Snapshot.Total().Total)
+	for i, c := range s.claims {
+		user := []string{"alice", "bob"}[i%2]
+		require.Equal(t, user, c["sub"])
+		require.Equal(t, "goblast", c["iss"])
+		require.Equal(t, float64(3), c["level"])
+		require.Equal(t, map[string]interface{}{"role": map[string]string{"alice": "admin", "bob": "user"}[user], "blaster": "#0"}, c["context"])
+
+		iat := time.Unix(int64(c["iat"].(float64)), 0)
+		require.WithinDuration(t, time.Now(), iat, 5*time.Second)
+		require.Equal(t, c["iat"].(float64)+300, c["exp"])
+	}
+}
+
+func TestJWTKeyFileAndNumericClaims(t *testing.T) {
+	s := &jwtServer{}
+	url := s.start(t, "Authorization", func(signed, sig []byte) bool {
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	})
+
+	// The trailing newline of the key file isn't part of the key
+	dir := t.TempDir()
+	writeFile(t, dir, "key", "s3cret\n")
+	writeFile(t, dir, "users.csv", "id,expires\nalice,4102444800\n")
+	file := writeFile(t, dir, "blast.yml", fmt.Sprintf(`
+request:
+  url: %s
+jwt:
+  algorithm: HS256
+  key_file: key
+  data: users.csv
+  claims:
+    sub: "{{.Row.id}}"
+    exp: "{{.Row.expires}}"
+    nbf: "1700000000"
+    code: "{{.Row.expires}}"
+`, url))
+
+	runJWT(t, file, 1)
+
+	// The registered times are numbers, other claims are kept as strings
+	for _, c := range s.claims {
+		require.Equal(t, float64(4102444800), c["exp"])
+		require.Equal(t, float64(1700000000), c["nbf"])
+		require.Equal(t, "4102444800", c["code"])
+	}
+}
+
+func TestJWTRS256PerBlaster(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	s := &jwtServer{}
+	url := s.start(t, "Authorization", func(signed, sig []byte) bool {
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig) == nil
+	})
+
+	dir := t.TempDir()
+	writeFile(t, dir, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})))
+	file := writeFile(t, dir, "blast.yml", fmt.Sprintf(`
+request:
+  url: %s
+jwt:
+  algorithm: RS256
+  key_file: key.pem
+  mint: blaster
+  claims: {sub: "user-{{.BlasterID}}"}
+`, url))
+
+	runJWT(t, file, 2)
+
+	// One token is minted for each blaster
+	require.Len(t, s.tokens, 2)
+}
+
+func TestJWTES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	s := &jwtServer{}
+	url := s.start(t, "Authorization", func(signed, sig []byte) bool {
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return len(sig) == 64 && ecdsa.Verify(&key.PublicKey, digest[:], r, s)
+	})
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	writeFile(t, dir, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	file := writeFile(t, dir, "blast.yml", fmt.Sprintf(`
+request:
+  url: %s
+jwt:
+  algorithm: ES256
+  key_file: key.pem
+  kid: test
+  claims: {jti: "{{uuid}}"}
+`, url))
+
+	runJWT(t, file, 1)
+	require.Len(t, s.tokens, len(s.claims))
+}
+
+func TestJWTErrors(t *testing.T) {
+	_, err := blaster.ParseFile("testdata/blast.yml", []byte(`
+request:
+  url: http://localhost
+jwt:
+  algorithm: RS256
+  key_file: body.xml
+  mint: always
+  claims:
+    sub: "{{.Row.id"
+`))
+	require.EqualError(t, err, strings.Join([]string{
+		"testdata/blast.yml:6:13: the key must be a PEM encoded private key",
+		"testdata/blast.yml:7:9: mint must be request or blaster, not always",
+		`testdata/blast.yml:9:10: template: :1: unclosed action`,
+	}, "\n"))
+
+	_, err = blaster.ParseFile("testdata/blast.yml", []byte("request: {url: http://localhost}\njwt: {algorithm: none}\n"))
+	require.EqualError(t, err, "testdata/blast.yml:2:18: unsupported algorithm none, use HS256, RS256 or ES256")
+}
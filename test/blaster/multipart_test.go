@@ -0,0 +1,149 @@
+package blastertest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipartBody(t *testing.T) {
+	type upload struct {
+		length      int64
+		chunked     bool
+		id          string
+		filename    string
+		contentType string
+		content     string
+	}
+
+	var mu sync.Mutex
+	uploads := []upload{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := upload{length: r.ContentLength, chunked: len(r.TransferEncoding) > 0}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u.id = r.FormValue("id")
+
+		f, header, err := r.FormFile("upload")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		b, _ := ioutil.ReadAll(f)
+		u.filename = header.Filename
+		u.contentType = header.Header.Get("Content-Type")
+		u.content = string(b)
+
+		mu.Lock()
+		uploads = append(uploads, u)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	config, err := blaster.ParseFile("testdata/blast.yml", []byte(fmt.Sprintf(`
+request:
+  url: %s
+  method: post
+  multipart:
+    fields:
+      - name: id
+        value: "{{.BlasterID}}-{{.Iteration}}"
+    files:
+      - name: upload
+        path: body.xml
+        filename: "item-{{.Iteration}}.xml"
+`, server.URL)))
+	require.NoError(t, err)
+	config.Duration = time.Second
+
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 0)
+	require.Equal(t, total.Total, total.Successful)
+	require.Len(t, uploads, total.Total)
+
+	var sent int64
+	for i, u := range uploads {
+		require.Greater(t, u.length, int64(0))
+		require.False(t, u.chunked)
+		require.True(t, strings.HasSuffix(u.id, fmt.Sprintf("-%d", i)), u.id)
+		require.Equal(t, fmt.Sprintf("item-%d.xml", i), u.filename)
+		require.Equal(t, "text/xml; charset=utf-8", u.contentType)
+		require.Equal(t, `<item id="1"/>`, u.content)
+		sent += u.length
+	}
+	require.Equal(t, sent, total.BytesSent)
+}
+
+func TestMultipartBodyLength(t *testing.T) {
+	body, err := blaster.NewMultipartBody(
+		[]blaster.MultipartField{{Name: "a", Value: "one"}, {Name: "b", Value: "{{.Name}}"}},
+		[]blaster.MultipartFile{{Name: "file", Path: filepath.Join("testdata", "body.xml"), ContentType: "application/xml"}},
+	)
+	require.NoError(t, err)
+
+	r, contentType, length, err := body.Open(blaster.RequestInfo{Name: "upload"})
+	require.NoError(t, err)
+	defer r.Close()
+	require.True(t, strings.HasPrefix(contentType, "multipart/form-data; boundary="))
+
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(b)), length)
+	require.Contains(t, string(b), "\r\n\r\nupload\r\n")
+	require.Contains(t, string(b), `filename="body.xml"`)
+	require.Contains(t, string(b), "Content-Type: application/xml")
+}
+
+func TestMultipartBodyErrors(t *testing.T) {
+	_, err := blaster.ParseFile("testdata/blast.yml", []byte(`
+request:
+  url: http://localhost
+  multipart:
+    fields:
+      - name: id
+        value: "{{.Missing"
+    files:
+      - name: upload
+        path: missing.bin
+      - path: body.xml
+`))
+	require.Error(t, err)
+	msg := err.Error()
+	require.Contains(t, msg, "testdata/blast.yml:7:")
+	require.Contains(t, msg, "testdata/blast.yml:10:")
+	require.Contains(t, msg, "missing.bin")
+	require.Contains(t, msg, "the name of a file field is required")
+
+	_, err = blaster.ParseFile("testdata/blast.yml", []byte(`
+request:
+  url: http://localhost
+  body_raw: hello
+  multipart:
+    fields: [{name: id, value: "1"}]
+`))
+	require.EqualError(t, err, "testdata/blast.yml:6:5: only one body may be given, not body_raw and multipart")
+
+	// Unknown fields of the template data fail the request, not the file
+	body, err := blaster.NewMultipartBody([]blaster.MultipartField{{Name: "id", Value: "{{.Missing}}"}}, nil)
+	require.NoError(t, err)
+	_, _, _, err = body.Open(blaster.RequestInfo{})
+	require.Error(t, err)
+}
@@ -0,0 +1,81 @@
+package blastertest
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+const profilesFile = "testdata/profiles/blast.yml"
+
+func TestListProfiles(t *testing.T) {
+	profiles, err := blaster.ListProfiles(profilesFile)
+	require.NoError(t, err)
+	require.Equal(t, []string{"load", "loop", "smoke", "soak"}, profiles)
+}
+
+func TestLoadProfileDefaults(t *testing.T) {
+	config, err := blaster.LoadProfile(profilesFile, "")
+	require.NoError(t, err)
+	require.Equal(t, "", config.Profile)
+
+	// Extended from the base file, but the rate of the included file is ignored
+	require.Equal(t, 5, config.Rate)
+	require.Equal(t, "http://localhost/items", config.URL.String())
+	require.Equal(t, "application/json", config.Header.Get("Accept"))
+	require.Equal(t, "platform", config.Header.Get("X-Team"))
+
+	// Relative to the file it's defined in
+	require.Equal(t, filepath.Join("testdata", "profiles", "base", "scenario.lua"), config.Script)
+}
+
+func TestLoadProfile(t *testing.T) {
+	tests := []struct {
+		profile  string
+		rate     int
+		duration time.Duration
+		method   string
+	}{
+		// The included profile overrides the one in the base file
+		{"smoke", 2, 5 * time.Second, http.MethodGet},
+		{"load", 50, 60 * time.Second, http.MethodPost},
+		// Extends load, from another file
+		{"soak", 50, 900 * time.Second, http.MethodPost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			config, err := blaster.LoadProfile(profilesFile, tt.profile)
+			require.NoError(t, err)
+			require.Equal(t, tt.profile, config.Profile)
+			require.Equal(t, tt.rate, config.Rate)
+			require.Equal(t, tt.duration, config.Duration)
+			require.Equal(t, tt.method, config.HTTPMethod)
+			require.Equal(t, "platform", config.Header.Get("X-Team"))
+		})
+	}
+}
+
+func TestLoadProfileErrors(t *testing.T) {
+	_, err := blaster.LoadProfile(profilesFile, "loop")
+	require.EqualError(t, err, profilesFile+": profile loop extends itself: loop -> loop")
+
+	_, err = blaster.LoadProfile(profilesFile, "missing")
+	require.EqualError(t, err, profilesFile+": unknown profile missing, the profiles are: load, loop, smoke, soak")
+
+	_, err = blaster.ParseProfile("blast.yml", []byte(`
+request:
+  url: http://localhost
+profiles:
+  load:
+    rat: 10
+`), "load")
+	require.EqualError(t, err, "blast.yml:6:5: unknown field rat, did you mean rate?")
+
+	_, err = blaster.ParseFile("blast.yml", []byte("extends: missing.yml\n"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "blast.yml:1:10:")
+}
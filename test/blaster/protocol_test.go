@@ -0,0 +1,124 @@
+package blastertest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// h2cServer serves both HTTP/1.1 and cleartext HTTP/2.
+func h2cServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.Protocols = &http.Protocols{}
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func runProtocol(t *testing.T, file string, blasters int) *blaster.RequestStats {
+	config, err := blaster.ParseFile("blast.yml", []byte(file))
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+
+	runner, err := blaster.NewRunner(config, blasters)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 0)
+	require.Equal(t, total.Total, total.Successful)
+	return total
+}
+
+func TestProtocols(t *testing.T) {
+	server := h2cServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	for protocol, proto := range map[string]string{
+		"auto":  "HTTP/1.1",
+		"http1": "HTTP/1.1",
+		"h2c":   "HTTP/2.0",
+	} {
+		t.Run(protocol, func(t *testing.T) {
+			total := runProtocol(t, fmt.Sprintf("request: {url: %s}\nhttp: {protocol: %s}\n", server.URL, protocol), 1)
+			require.Equal(t, map[string]int{proto: total.Total}, total.Protocols)
+		})
+	}
+
+	// HTTP/2 is only negotiated over TLS
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf("request: {url: %s}\nhttp: {protocol: http2}\n", server.URL)))
+	require.NoError(t, err)
+	config.Duration = 300 * time.Millisecond
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, result.Snapshot.Total().Successful)
+}
+
+func TestConnectionsAndStreams(t *testing.T) {
+	var mu sync.Mutex
+	active, max := map[string]int{}, 0
+	server := h2cServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active[r.RemoteAddr]++
+		if active[r.RemoteAddr] > max {
+			max = active[r.RemoteAddr]
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active[r.RemoteAddr]--
+		mu.Unlock()
+	})
+
+	total := runProtocol(t, fmt.Sprintf(`
+rate: 100
+request:
+  url: %s
+http:
+  protocol: h2c
+  connections: 2
+  max_streams: 2
+`, server.URL), 8)
+	require.Equal(t, total.Total, total.Protocols["HTTP/2.0"])
+
+	// The requests of all blasters are sent over the two connections,
+	// with at most two streams each
+	require.Len(t, active, 2)
+	require.Equal(t, 2, max)
+	require.Equal(t, 2, total.NewConnections)
+}
+
+func TestHTTPConfig(t *testing.T) {
+	config, err := blaster.ParseFile("blast.yml", []byte("request: {url: http://localhost}\nhttp: {protocol: h2c, connections: 4}\n"))
+	require.NoError(t, err)
+	require.Equal(t, "h2c, 4 connections", config.HTTP().String())
+
+	// The configuration is kept when sent to another process
+	decoded := roundTrip(t, config)
+	require.Equal(t, config.HTTP(), decoded.HTTP())
+
+	_, err = blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: http://localhost
+http:
+  protocol: spdy
+  max_streams: -1
+`))
+	require.EqualError(t, err, strings.Join([]string{
+		"blast.yml:5:13: unsupported protocol spdy, use auto, http1, http2 or h2c",
+		"blast.yml:6:16: max_streams must not be negative",
+	}, "\n"))
+}
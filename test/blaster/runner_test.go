@@ -0,0 +1,103 @@
+package blastertest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL+"/?fail=yes", http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = time.Second
+
+	runner, err := blaster.NewRunner(config, 2)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	results := []blaster.RequestResult{}
+	runner.OnResult = func(r blaster.RequestResult) {
+		mu.Lock()
+		results = append(results, r)
+		mu.Unlock()
+	}
+
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.InDelta(t, 20, total.Total, 4)
+	require.Equal(t, 0, total.Successful)
+	require.Equal(t, total.Total, total.Status[http.StatusBadGateway])
+	require.Equal(t, 2, result.Blasters)
+	require.Equal(t, 20.0, result.TargetRate)
+	require.True(t, result.End.After(result.Start))
+
+	require.Len(t, results, total.Total)
+	ids := map[string]bool{}
+	for _, r := range results {
+		ids[r.BlasterID] = true
+		require.Equal(t, http.StatusBadGateway, r.StatusCode)
+		require.False(t, r.Successful())
+		require.Equal(t, "GET /", r.Name)
+	}
+	require.Len(t, ids, 2)
+}
+
+func TestRunnerCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 10, 60, http.Header{})
+	require.NoError(t, err)
+
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	result, err := runner.Run(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.True(t, result.Elapsed < time.Second)
+	require.Greater(t, result.Snapshot.Total().Total, 0)
+	require.Equal(t, "stopped", result.Annotations[len(result.Annotations)-1].Message)
+}
+
+func TestResultJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = time.Second
+
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	b, err := json.Marshal(result)
+	require.NoError(t, err)
+
+	var decoded blaster.Result
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.True(t, result.End.Equal(decoded.End))
+	require.Equal(t, result.Elapsed, decoded.Elapsed)
+	require.Equal(t, result.Blasters, decoded.Blasters)
+	require.Equal(t, result.Snapshot, decoded.Snapshot)
+}
@@ -0,0 +1,105 @@
+package blastertest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// sessionServer sets a session cookie on each request without one,
+// and counts the requests of each session and the connections.
+type sessionServer struct {
+	mu          sync.Mutex
+	sessions    map[string]int
+	connections int
+}
+
+func (s *sessionServer) start(t *testing.T) string {
+	s.sessions = map[string]int{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		cookie, err := r.Cookie("sid")
+		if err != nil {
+			cookie = &http.Cookie{Name: "sid", Value: fmt.Sprint(len(s.sessions))}
+			http.SetCookie(w, cookie)
+		}
+		s.sessions[cookie.Value]++
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.mu.Lock()
+			s.connections++
+			s.mu.Unlock()
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func runSession(t *testing.T, url, session string, blasters int) *blaster.RequestStats {
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf("rate: 20\nrequest: {url: %s}\n%s", url, session)))
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+
+	runner, err := blaster.NewRunner(config, blasters)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 5*blasters)
+	require.Equal(t, total.Total, total.Successful)
+	return total
+}
+
+func TestSessionCookies(t *testing.T) {
+	// Without a session each request is a new user
+	s := &sessionServer{}
+	total := runSession(t, s.start(t), "", 1)
+	require.Len(t, s.sessions, total.Total)
+
+	// Each blaster keeps its cookies for three iterations
+	s = &sessionServer{}
+	total = runSession(t, s.start(t), "session: {cookies: true, reset_every: 3}\n", 2)
+
+	full := 0
+	for _, n := range s.sessions {
+		require.LessOrEqual(t, n, 3)
+		if n == 3 {
+			full++
+		}
+	}
+	require.GreaterOrEqual(t, full, total.Total/3-2)
+	require.LessOrEqual(t, len(s.sessions), total.Total/3+2)
+}
+
+func TestSessionConnections(t *testing.T) {
+	// The connection of a blaster is closed when its session is reset
+	s := &sessionServer{}
+	total := runSession(t, s.start(t), "session: {connections: true, reset_every: 2}\n", 1)
+	require.GreaterOrEqual(t, s.connections, total.Total/2)
+	require.LessOrEqual(t, s.connections, total.Total/2+1)
+
+	// The cookies are not kept unless configured
+	require.Len(t, s.sessions, total.Total)
+}
+
+func TestSessionErrors(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte("request: {url: http://localhost}\nsession: {reset_every: -1}\n"))
+	require.EqualError(t, err, strings.Join([]string{
+		"blast.yml:2:10: a session must keep cookies, connections or both",
+		"blast.yml:2:24: reset_every must not be negative",
+	}, "\n"))
+}
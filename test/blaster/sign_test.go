@@ -0,0 +1,216 @@
+package blastertest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// The examples of the AWS Signature Version 4 test suite.
+func TestAWSSigner(t *testing.T) {
+	signer := &blaster.AWSSigner{
+		Service:         "service",
+		Region:          "us-east-1",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	date := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		url           string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			"get-vanilla",
+			"https://example.amazonaws.com/",
+			"host;x-amz-date",
+			"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			"get-vanilla-query-order-key-case",
+			"https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			"host;x-amz-date",
+			"b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			"get-vanilla-query-order-key",
+			"https://example.amazonaws.com/?Param1=value2&Param1=Value1",
+			"host;x-amz-date",
+			"eedbc4e291e521cf13422ffca22be7d2eb8146eecf653089df300a15b2382bd1",
+		},
+		{
+			"get-vanilla-query-unreserved",
+			"https://example.amazonaws.com/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			"host;x-amz-date",
+			"9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			require.NoError(t, err)
+			require.NoError(t, signer.Sign(req, date))
+
+			require.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+			require.Equal(t, fmt.Sprintf(
+				"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=%s, Signature=%s",
+				tt.signedHeaders, tt.signature),
+				req.Header.Get("Authorization"))
+		})
+	}
+}
+
+// The query is sorted by name and then by value, not as the joined
+// pairs where a-b=1 would come before a=2.
+func TestAWSSignerQueryOrder(t *testing.T) {
+	signer := &blaster.AWSSigner{Service: "service", Region: "us-east-1", AccessKeyID: "id", SecretAccessKey: "secret"}
+	date := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		"/",
+		"a=2&a-b=1",
+		"host:example.amazonaws.com\nx-amz-date:20150830T123600Z\n",
+		"host;x-amz-date",
+		hexSHA256(""),
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		"20150830T123600Z",
+		"20150830/us-east-1/service/aws4_request",
+		hexSHA256(canonicalRequest),
+	}, "\n")
+	key := hmacSHA256("AWS4secret", "20150830")
+	for _, part := range []string{"us-east-1", "service", "aws4_request"} {
+		key = hmacSHA256(string(key), part)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/?a-b=1&a=2", nil)
+	require.NoError(t, err)
+	require.NoError(t, signer.Sign(req, date))
+	require.True(t, strings.HasSuffix(req.Header.Get("Authorization"), "Signature="+hex.EncodeToString(hmacSHA256(string(key), stringToSign))))
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key, data string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func TestAWSSigningFromEnv(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu-north-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+
+	config, err := blaster.ParseFile("blast.yml", []byte("request: {url: https://example.com/items}\nsigning: {type: aws_sigv4, service: execute-api}\n"))
+	require.NoError(t, err)
+	require.Equal(t, "eu-north-1", config.Signing().Region)
+	require.Equal(t, "****", config.Mask("secret"))
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	_, err = blaster.ParseFile("blast.yml", []byte("request: {url: https://example.com/items}\nsigning: {type: aws_sigv4, region: eu-north-1}\n"))
+	require.EqualError(t, err, strings.Join([]string{
+		"blast.yml:2:10: service is required by aws_sigv4 signing",
+		"blast.yml:2:10: access_key_id is required by aws_sigv4 signing",
+		"blast.yml:2:10: secret_access_key is required by aws_sigv4 signing",
+	}, "\n"))
+}
+
+func TestHMACSigning(t *testing.T) {
+	sign := func(s string) string {
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(s))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	bodies := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies[string(body)] = true
+
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+		expected := sign(strings.Join([]string{
+			r.Method,
+			r.URL.RequestURI(),
+			"authorization:" + r.Header.Get("Authorization"),
+			"x-timestamp:" + r.Header.Get("X-Timestamp"),
+			string(body),
+		}, "\n"))
+		if r.Header.Get("Authorization") != "Bearer abc" ||
+			time.Since(time.Unix(timestamp, 0)) > 5*time.Second ||
+			r.Header.Get("X-Hub-Signature") != expected {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	// The signature is computed after the authentication,
+	// and of the body rendered for each request
+	config, err := blaster.ParseFile("testdata/blast.yml", []byte(fmt.Sprintf(`
+request:
+  url: %s/hooks?source=blast
+  method: post
+  multipart:
+    fields: [{name: id, value: "{{.Iteration}}"}]
+auth:
+  type: bearer
+  token: abc
+signing:
+  type: hmac
+  secret: s3cret
+  header: X-Hub-Signature
+  headers: [Authorization]
+  body: true
+  timestamp_header: X-Timestamp
+  prefix: sha256=
+`, server.URL)))
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+
+	runner, err := blaster.NewRunner(config, 1)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	total := result.Snapshot.Total()
+	require.Greater(t, total.Total, 1)
+	require.Equal(t, total.Total, total.Successful)
+	require.Len(t, bodies, total.Total)
+}
+
+func TestSigningErrors(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: http://localhost
+signing:
+  type: hmac
+  algorithm: md5
+  body: yes please
+`))
+	require.EqualError(t, err, strings.Join([]string{
+		"blast.yml:5:3: secret is required by hmac signing",
+		"blast.yml:6:14: unsupported algorithm md5, use sha1, sha256 or sha512",
+		`blast.yml:7:9: signing.body must be true or false, not "yes please"`,
+	}, "\n"))
+}
@@ -0,0 +1,52 @@
+package blastertest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/lunjon/go-blast/pkg/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionPhases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("slow"))
+	}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 20, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = time.Second
+
+	group, err := blaster.NewGroup(config, 1)
+	require.NoError(t, err)
+	group.Start()
+	group.Wait()
+
+	total := group.Snapshot().Total()
+	require.Greater(t, total.Total, 10)
+
+	// The connection is kept alive, so only the first request needs to connect
+	require.Equal(t, 1, total.NewConnections)
+	require.Equal(t, total.Total-1, total.ReusedConnections)
+	require.Equal(t, uint64(1), total.Phases[blaster.PhaseConnect].Count)
+
+	// The server is local so no DNS lookup or TLS handshake is made
+	require.NotContains(t, total.Phases, blaster.PhaseDNS)
+	require.NotContains(t, total.Phases, blaster.PhaseTLS)
+
+	firstByte := total.Phases[blaster.PhaseFirstByte]
+	require.Equal(t, uint64(total.Total), firstByte.Count)
+	require.True(t, firstByte.Mean() >= 5*time.Millisecond, "mean time to first byte %v", firstByte.Mean())
+	require.True(t, total.Latency.Mean() >= firstByte.Mean())
+
+	var sb strings.Builder
+	require.NoError(t, metrics.WritePrometheus(&sb, group))
+	require.Contains(t, sb.String(), `goblast_request_phase_duration_seconds_count{request="GET /",phase="first_byte"}`)
+	require.Contains(t, sb.String(), `goblast_connections_total{request="GET /",reused="false"} 1`)
+}
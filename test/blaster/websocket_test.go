@@ -0,0 +1,266 @@
+package blastertest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/stretchr/testify/require"
+)
+
+// chatServer replies to each message with a message containing it,
+// after a broadcast that matches no message, and closes each
+// connection after closeAfter messages if positive.
+type chatServer struct {
+	*httptest.Server
+	closeAfter int
+
+	mu       sync.Mutex
+	tokens   []string
+	received []string
+}
+
+func newChatServer(t *testing.T, closeAfter int) *chatServer {
+	s := &chatServer{closeAfter: closeAfter}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		s.mu.Lock()
+		s.tokens = append(s.tokens, r.Header.Get("Authorization"))
+		s.mu.Unlock()
+		for i := 1; ; i++ {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.received = append(s.received, string(data))
+			s.mu.Unlock()
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"broadcast"}`))
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"reply","message":`+string(data)+`}`))
+			if i == s.closeAfter {
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *chatServer) url() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/chat"
+}
+
+func runWebSocket(t *testing.T, config *blaster.Configuration, blasters int) blaster.Snapshot {
+	config.Duration = 500 * time.Millisecond
+	runner, err := blaster.NewRunner(config, blasters)
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+	return result.Snapshot
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	server := newChatServer(t, 0)
+	dir := t.TempDir()
+	writeFile(t, dir, "users.csv", "user\nalice\nbob\n")
+	file := writeFile(t, dir, "blast.yml", fmt.Sprintf(`
+rate: 40
+request:
+  url: %s
+auth:
+  type: bearer
+  token: s3cret
+websocket:
+  connections: 3
+  messages:
+    - '{"id":"{{.BlasterID}}-{{.Iteration}}","user":"{{.Row.user}}"}'
+  data: users.csv
+  expect: '"id":"{{.BlasterID}}-{{.Iteration}}"'
+`, server.url()))
+
+	config, err := blaster.LoadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, "WS /chat", config.Name)
+	require.Equal(t, "3 connections per blaster, 1 message, expecting replies within 10s", config.WebSocket().String())
+
+	snapshot := runWebSocket(t, config, 2)
+
+	// Each blaster keeps its connections open
+	connects := snapshot.Requests[blaster.WebSocketConnectName]
+	require.Equal(t, 6, connects.Total)
+	require.Equal(t, 6, connects.Successful)
+	require.Equal(t, map[int]int{http.StatusSwitchingProtocols: 6}, connects.Status)
+	require.Nil(t, snapshot.Requests[blaster.WebSocketDisconnectName])
+	server.mu.Lock()
+	require.Equal(t, []string{"Bearer s3cret"}, unique(server.tokens))
+	received := strings.Join(server.received, "\n")
+	require.Contains(t, received, `"user":"alice"`)
+	require.Contains(t, received, `"user":"bob"`)
+	server.mu.Unlock()
+
+	// The messages are measured by their round trip
+	messages := snapshot.Requests["WS /chat"]
+	require.Greater(t, messages.Total, 10)
+	require.Equal(t, messages.Total, messages.Successful)
+	require.EqualValues(t, messages.Total, messages.Latency.Count)
+	require.Equal(t, messages.Total, messages.ReusedConnections)
+	require.Empty(t, messages.Status)
+
+	// The configuration is kept when sent to another process
+	config.SendSecrets = true
+	decoded := roundTrip(t, config)
+	expected := *config.WebSocket()
+	expected.DataFile = ""
+	require.Equal(t, &expected, decoded.WebSocket())
+}
+
+func unique(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// roundTrip returns config as received by another process,
+// which only accepts the durations of a blast.
+func roundTrip(t *testing.T, config *blaster.Configuration) *blaster.Configuration {
+	config.Duration = blaster.MinDuration * time.Second
+	b, err := json.Marshal(config)
+	require.NoError(t, err)
+	decoded := &blaster.Configuration{}
+	require.NoError(t, json.Unmarshal(b, decoded))
+	return decoded
+}
+
+func TestWebSocketDisconnects(t *testing.T) {
+	// Each connection is closed after two messages
+	server := newChatServer(t, 2)
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf(`
+rate: 20
+request:
+  url: %s
+websocket:
+  messages: ['"hello"']
+`, server.url())))
+	require.NoError(t, err)
+
+	snapshot := runWebSocket(t, config, 1)
+	messages := snapshot.Requests["WS /chat"]
+	require.Greater(t, messages.Total, 4)
+	require.Equal(t, messages.Total, messages.Successful)
+
+	// The lost connections are reconnected
+	disconnects := snapshot.Requests[blaster.WebSocketDisconnectName]
+	require.NotNil(t, disconnects)
+	require.Equal(t, disconnects.Total, disconnects.Errors["disconnect"])
+	require.GreaterOrEqual(t, snapshot.Requests[blaster.WebSocketConnectName].Total, disconnects.Total)
+}
+
+func TestWebSocketReplyTimeout(t *testing.T) {
+	server := newChatServer(t, 0)
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf(`
+rate: 20
+request:
+  url: %s
+websocket:
+  messages: ['"hello"']
+  expect: never
+`, server.url())))
+	require.NoError(t, err)
+	config.WebSocket().Timeout = 100 * time.Millisecond
+
+	snapshot := runWebSocket(t, config, 1)
+	messages := snapshot.Requests["WS /chat"]
+	require.Greater(t, messages.Total, 0)
+	require.Equal(t, messages.Total, messages.Errors["timeout"])
+}
+
+func TestWebSocketHandshakeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf("request: {url: %s}\nwebsocket: {messages: [hello]}\n", "ws"+strings.TrimPrefix(server.URL, "http"))))
+	require.NoError(t, err)
+
+	snapshot := runWebSocket(t, config, 1)
+	connects := snapshot.Requests[blaster.WebSocketConnectName]
+	require.Greater(t, connects.Total, 1)
+	require.Equal(t, connects.Total, connects.Errors["handshake"])
+	require.Equal(t, connects.Total, connects.Status[http.StatusForbidden])
+	require.Nil(t, snapshot.Requests["WS /"])
+}
+
+func TestWebSocketBinary(t *testing.T) {
+	types := make(chan int, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			types <- messageType
+		}
+	}))
+	defer server.Close()
+
+	// The handshake may also be given with an http URL
+	config, err := blaster.ParseFile("blast.yml", []byte(fmt.Sprintf("rate: 20\nrequest: {url: %s}\nwebsocket: {messages: [hello], binary: true}\n", server.URL+"/binary")))
+	require.NoError(t, err)
+
+	snapshot := runWebSocket(t, config, 1)
+	messages := snapshot.Requests[config.Name]
+	require.Greater(t, messages.Total, 0)
+	require.Equal(t, messages.Total, messages.Successful)
+	require.Equal(t, websocket.BinaryMessage, <-types)
+}
+
+func TestWebSocketErrors(t *testing.T) {
+	_, err := blaster.ParseFile("blast.yml", []byte(`
+request:
+  url: ws://localhost/chat
+websocket:
+  connections: -1
+  messages: ['{{.Missing']
+  timeout: -5
+`))
+	require.EqualError(t, err, strings.Join([]string{
+		"blast.yml:5:16: connections must not be negative",
+		"blast.yml:6:14: template: :1: unclosed action",
+		"blast.yml:7:12: timeout must not be negative",
+	}, "\n"))
+
+	_, err = blaster.ParseFile("blast.yml", []byte(`
+script: chat.lua
+request:
+  url: ws://localhost/chat
+websocket:
+  messages_file: messages.txt
+`))
+	require.EqualError(t, err, "blast.yml:6:3: only one of script and websocket may be given")
+}
@@ -0,0 +1,148 @@
+package controltest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/lunjon/go-blast/pkg/control"
+	"github.com/stretchr/testify/require"
+)
+
+type controlTest struct {
+	t      *testing.T
+	target *httptest.Server
+	server *httptest.Server
+	group  *blaster.Group
+}
+
+func newControlTest(t *testing.T, duration time.Duration) *controlTest {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	config, err := blaster.NewConfiguration(target.URL, http.MethodGet, 20, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = duration
+
+	group, err := blaster.NewGroup(config, 1)
+	require.NoError(t, err)
+
+	return &controlTest{
+		t:      t,
+		target: target,
+		server: httptest.NewServer(control.Handler(group)),
+		group:  group,
+	}
+}
+
+func (c *controlTest) close() {
+	c.group.Stop()
+	c.server.Close()
+	c.target.Close()
+}
+
+func (c *controlTest) post(path string, values url.Values) (int, control.Status) {
+	res, err := http.PostForm(c.server.URL+path, values)
+	require.NoError(c.t, err)
+	defer res.Body.Close()
+
+	status := control.Status{}
+	if res.StatusCode == http.StatusOK {
+		require.NoError(c.t, json.NewDecoder(res.Body).Decode(&status))
+	}
+	return res.StatusCode, status
+}
+
+func TestPauseAndResume(t *testing.T) {
+	c := newControlTest(t, 5*time.Second)
+	defer c.close()
+	c.group.Start()
+
+	time.Sleep(200 * time.Millisecond)
+	code, status := c.post("/pause", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, status.Paused)
+
+	// No requests are sent while paused
+	time.Sleep(100 * time.Millisecond)
+	before := c.group.Snapshot().Total().Total
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, before, c.group.Snapshot().Total().Total)
+
+	_, status = c.post("/resume", nil)
+	require.False(t, status.Paused)
+	time.Sleep(300 * time.Millisecond)
+	require.Greater(t, c.group.Snapshot().Total().Total, before)
+
+	require.Len(t, status.Annotations, 2)
+	require.Equal(t, "paused", status.Annotations[0].Message)
+	require.Equal(t, "resumed", status.Annotations[1].Message)
+}
+
+func TestChangeRateAndBlasters(t *testing.T) {
+	c := newControlTest(t, 5*time.Second)
+	defer c.close()
+	c.group.Start()
+
+	code, status := c.post("/rate", url.Values{"value": {"50"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 50, status.Rate)
+
+	code, status = c.post("/blasters", url.Values{"value": {"3"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 3, status.Blasters)
+	require.Equal(t, 150.0, c.group.TargetRate())
+
+	// Requests sent during one second should now be close to 150
+	before := c.group.Snapshot().Total().Total
+	time.Sleep(time.Second)
+	require.InDelta(t, 150, c.group.Snapshot().Total().Total-before, 30)
+
+	code, _ = c.post("/rate", url.Values{"value": {"1000"}})
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = c.post("/blasters", url.Values{"value": {"none"}})
+	require.Equal(t, http.StatusBadRequest, code)
+
+	messages := []string{}
+	for _, a := range c.group.Annotations() {
+		messages = append(messages, a.Message)
+	}
+	require.Equal(t, []string{"rate changed from 20 to 50", "blasters changed from 1 to 3"}, messages)
+}
+
+func TestStopEarly(t *testing.T) {
+	c := newControlTest(t, time.Minute)
+	defer c.close()
+
+	done := make(chan struct{})
+	c.group.Start()
+	go func() {
+		c.group.Wait()
+		close(done)
+	}()
+
+	code, _ := c.post("/stop", nil)
+	require.Equal(t, http.StatusOK, code)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("blast was not stopped")
+	}
+
+	res, err := http.Get(c.server.URL + "/status")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	status := control.Status{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+	require.Equal(t, 0, status.ActiveBlasters)
+	require.Equal(t, "stopped", status.Annotations[len(status.Annotations)-1].Message)
+
+	// The blast is done so no more blasters can be added
+	code, _ = c.post("/blasters", url.Values{"value": {"2"}})
+	require.Equal(t, http.StatusBadRequest, code)
+}
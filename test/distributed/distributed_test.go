@@ -0,0 +1,245 @@
+package distributedtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/lunjon/go-blast/pkg/distributed"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	require.Equal(t, []int{4, 3, 3}, distributed.Split(10, 3))
+	require.Equal(t, []int{1, 1, 0}, distributed.Split(2, 3))
+	require.Equal(t, []int{100, 100}, distributed.Split(200, 2))
+}
+
+func TestCoordinateAgents(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	var agents []string
+	for i := 0; i < 3; i++ {
+		agent := httptest.NewServer(distributed.NewAgent())
+		defer agent.Close()
+		agents = append(agents, agent.URL)
+	}
+
+	config, err := blaster.NewConfiguration(target.URL, http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = blaster.MinDuration * time.Second
+
+	coordinator, err := distributed.NewCoordinator(agents)
+	require.NoError(t, err)
+	coordinator.StartDelay = 200 * time.Millisecond
+	coordinator.Interval = 200 * time.Millisecond
+
+	var mu sync.Mutex
+	updates := 0
+	coordinator.OnUpdate = func(m blaster.Metrics) {
+		mu.Lock()
+		updates++
+		mu.Unlock()
+	}
+
+	result, err := coordinator.Run(context.Background(), config, 6)
+	require.NoError(t, err)
+
+	// Each agent runs two blasters at 10 req/s for five seconds
+	require.Len(t, result.Agents, 3)
+	for _, s := range result.Agents {
+		require.InDelta(t, 100, s.Total().Total, 20)
+	}
+	require.InDelta(t, 300, result.Snapshot.Total().Total, 60)
+	require.Equal(t, result.Snapshot.Total().Total, result.Snapshot.Total().Successful)
+	require.Greater(t, updates, 3)
+}
+
+func TestAgentBusy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	agent := httptest.NewServer(distributed.NewAgent())
+	defer agent.Close()
+
+	config, err := blaster.NewConfiguration(target.URL, http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = blaster.MinDuration * time.Second
+
+	first, err := distributed.NewCoordinator([]string{agent.URL})
+	require.NoError(t, err)
+	first.StartDelay = 100 * time.Millisecond
+
+	done := make(chan error)
+	go func() {
+		_, err := first.Run(context.Background(), config, 1)
+		done <- err
+	}()
+
+	// Give the first coordinator time to send its job
+	time.Sleep(50 * time.Millisecond)
+
+	second, err := distributed.NewCoordinator([]string{agent.URL})
+	require.NoError(t, err)
+	_, err = second.Run(context.Background(), config, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "busy")
+
+	require.NoError(t, <-done)
+}
+
+func TestTooManyBlasters(t *testing.T) {
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+
+	coordinator, err := distributed.NewCoordinator([]string{"localhost"})
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:7070", coordinator.Agents[0])
+
+	_, err = coordinator.Run(context.Background(), config, distributed.MaxBlasters+1)
+	require.Error(t, err)
+}
+
+func TestAgentToken(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	agent := distributed.NewAgent()
+	agent.Token = "secret"
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(target.URL, http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = blaster.MinDuration * time.Second
+
+	coordinator, err := distributed.NewCoordinator([]string{server.URL})
+	require.NoError(t, err)
+	coordinator.StartDelay = 50 * time.Millisecond
+
+	_, err = coordinator.Run(context.Background(), config, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "401 Unauthorized")
+
+	coordinator.Token = "wrong"
+	_, err = coordinator.Run(context.Background(), config, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "401 Unauthorized")
+
+	coordinator.Token = "secret"
+	result, err := coordinator.Run(context.Background(), config, 1)
+	require.NoError(t, err)
+	require.Greater(t, result.Snapshot.Total().Total, 0)
+}
+
+func TestAgentServeRequiresToken(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	// An agent reachable from other machines needs a token
+	err = distributed.NewAgent().Serve(l)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "a token is required to accept jobs on")
+
+	agent := distributed.NewAgent()
+	agent.Token = "secret"
+	done := make(chan error)
+	go func() { done <- agent.Serve(l) }()
+	l.Close()
+	require.NotContains(t, (<-done).Error(), "token")
+}
+
+func TestSecretsNotSent(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	var mu sync.Mutex
+	var tokens []string
+	agent := distributed.NewAgent()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var job struct {
+			Config struct {
+				Auth *blaster.AuthConfig `json:"auth"`
+			} `json:"config"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &job)
+		mu.Lock()
+		tokens = append(tokens, job.Config.Auth.Token)
+		mu.Unlock()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		agent.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	config, err := blaster.NewConfiguration(target.URL, http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+	require.NoError(t, config.SetAuth(blaster.AuthConfig{Type: blaster.AuthBearer, Token: "s3cret"}))
+	config.Duration = blaster.MinDuration * time.Second
+
+	coordinator, err := distributed.NewCoordinator([]string{server.URL})
+	require.NoError(t, err)
+	coordinator.StartDelay = 50 * time.Millisecond
+
+	// The credentials are only sent if the operator opts in
+	_, err = coordinator.Run(context.Background(), config, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "only sent to another process if SendSecrets is set")
+
+	config.SendSecrets = true
+	_, err = coordinator.Run(context.Background(), config, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"s3cret"}, tokens)
+}
+
+func TestAgentDurationLimits(t *testing.T) {
+	agent := httptest.NewServer(distributed.NewAgent())
+	defer agent.Close()
+
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+	coordinator, err := distributed.NewCoordinator([]string{agent.URL})
+	require.NoError(t, err)
+
+	// The agent only runs blasts as long as any other
+	for _, d := range []time.Duration{time.Second, (blaster.MaxDuration + 1) * time.Second} {
+		config.Duration = d
+		_, err = coordinator.Run(context.Background(), config, 1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "400 Bad Request: invalid job: duration must be in the range 5-900 seconds")
+	}
+}
+
+func TestInvalidUpdate(t *testing.T) {
+	// An agent sending a histogram with more buckets than the coordinator has
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := map[string]interface{}{
+			"total":   1,
+			"latency": map[string]interface{}{"counts": make([]uint64, len(blaster.LatencyBuckets)+5), "count": 1},
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"snapshot": map[string]interface{}{"requests": map[string]interface{}{"GET /": stats}},
+			"done":     true,
+		})
+	}))
+	defer agent.Close()
+
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+
+	coordinator, err := distributed.NewCoordinator([]string{agent.URL})
+	require.NoError(t, err)
+	_, err = coordinator.Run(context.Background(), config, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid update: request GET /: latency: histogram has")
+}
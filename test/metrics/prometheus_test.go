@@ -0,0 +1,70 @@
+package metricstest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/lunjon/go-blast/pkg/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusEndpoint(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/forbidden" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer target.Close()
+
+	config, err := blaster.NewConfiguration(target.URL+"/forbidden", http.MethodGet, 20, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = time.Second
+
+	group, err := blaster.NewGroup(config, 2)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(metrics.Handler(group))
+	defer server.Close()
+
+	group.Start()
+	group.Wait()
+
+	res, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	body := string(b)
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain"))
+	require.Contains(t, body, `goblast_requests_total{request="GET /forbidden",status="403"}`)
+	require.Contains(t, body, `goblast_request_duration_seconds_bucket{request="GET /forbidden",le="+Inf"}`)
+	require.Contains(t, body, "goblast_target_rate 40\n")
+	require.Contains(t, body, "goblast_active_blasters 0\n")
+}
+
+func TestPrometheusErrors(t *testing.T) {
+	// Nothing is listening on the address of a closed server
+	target := httptest.NewServer(http.NotFoundHandler())
+	target.Close()
+
+	config, err := blaster.NewConfiguration(target.URL, http.MethodGet, 10, 0, http.Header{})
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+
+	group, err := blaster.NewGroup(config, 1)
+	require.NoError(t, err)
+	group.Start()
+	group.Wait()
+
+	var sb strings.Builder
+	require.NoError(t, metrics.WritePrometheus(&sb, group))
+	require.Contains(t, sb.String(), `goblast_request_errors_total{request="GET /",error="connection_refused"}`)
+}
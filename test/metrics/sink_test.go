@@ -0,0 +1,140 @@
+package metricstest
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/lunjon/go-blast/pkg/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+// testMetrics returns metrics for a single request named "GET /"
+// with total requests sent.
+func testMetrics(total int) blaster.Metrics {
+	snapshot := blaster.NewSnapshot()
+	snapshot.Requests["GET /"] = &blaster.RequestStats{
+		Total:      total,
+		Successful: total - 1,
+		Status:     map[int]int{200: total - 1},
+		Errors:     map[string]int{"timeout": 1},
+	}
+	snapshot.Requests["GET /"].Latency.Observe(15 * time.Millisecond)
+
+	return blaster.Metrics{
+		Time:           time.Unix(1500000000, 0),
+		Snapshot:       snapshot,
+		TargetRate:     10,
+		AchievedRate:   9.5,
+		ActiveBlasters: 1,
+	}
+}
+
+func TestStatsDSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := metrics.NewSink(blaster.SinkConfig{Type: "statsd", Address: conn.LocalAddr().String()}, "test")
+	require.NoError(t, err)
+	defer sink.Close()
+
+	read := func() string {
+		buf := make([]byte, 2048)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	require.NoError(t, sink.Push(testMetrics(10)))
+	packet := read()
+	require.Contains(t, packet, "test.request.GET__.requests:10|c\n")
+	require.Contains(t, packet, "test.request.GET__.errors:1|c\n")
+	require.Contains(t, packet, "test.target_rate:10|g\n")
+
+	// Counters are sent as the increase since the last push
+	require.NoError(t, sink.Push(testMetrics(15)))
+	require.Contains(t, read(), "test.request.GET__.requests:5|c\n")
+}
+
+func TestInfluxDBSink(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- r.URL.Query().Get("db") + "\n" + string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := metrics.NewSink(blaster.SinkConfig{Type: "influxdb", URL: server.URL + "/write?db=blast"}, "")
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Push(testMetrics(10)))
+	body := <-bodies
+	require.True(t, strings.HasPrefix(body, "blast\n"))
+	require.Contains(t, body, `goblast,request=GET\ / requests=10i,successful=9i,failed=0i,errors=1i,`)
+	require.Contains(t, body, "goblast target_rate=10,achieved_rate=9.5,active_blasters=1 1500000000\n")
+}
+
+func TestInfluxDBSinkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	sink, err := metrics.NewSink(blaster.SinkConfig{Type: "influxdb", URL: server.URL + "/write"}, "")
+	require.NoError(t, err)
+
+	err = sink.Push(testMetrics(10))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "database not found")
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	lines := make(chan string, 100)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	sink, err := metrics.NewSink(blaster.SinkConfig{Type: "graphite", Address: listener.Addr().String()}, "test")
+	require.NoError(t, err)
+	require.NoError(t, sink.Push(testMetrics(10)))
+	require.NoError(t, sink.Close())
+
+	var received []string
+	for line := range lines {
+		received = append(received, line)
+		if strings.HasPrefix(line, "test.active_blasters") {
+			break
+		}
+	}
+
+	require.Contains(t, received, "test.request.GET__.requests 10 1500000000")
+	require.Contains(t, received, "test.achieved_rate 9.5 1500000000")
+}
+
+func TestUnknownSink(t *testing.T) {
+	_, err := metrics.NewSink(blaster.SinkConfig{Type: "carrier-pigeon"}, "")
+	require.Error(t, err)
+}
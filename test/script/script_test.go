@@ -0,0 +1,269 @@
+package scripttest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lunjon/go-blast/pkg/blaster"
+	"github.com/lunjon/go-blast/pkg/script"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			User string `json:"user"`
+		}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil || body.User == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "token-" + body.User})
+	})
+	mux.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" || r.Header.Get("X-Default") != "yes" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"name": "first"}, {"name": "second"}]`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func run(t *testing.T, config *blaster.Configuration, blasters int) *blaster.Result {
+	runner, err := blaster.NewRunner(config, blasters)
+	require.NoError(t, err)
+
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+	return result
+}
+
+func TestScenario(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	config, err := blaster.LoadFile("testdata/scenario.yml")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("testdata", "scenario.lua"), config.Script)
+
+	require.NoError(t, config.SetURL(server.URL))
+	config.Header.Set("X-Default", "yes")
+	config.Duration = time.Second
+	config.Scenario, err = script.Load(config.Script)
+	require.NoError(t, err)
+
+	result := run(t, config, 2)
+
+	// Each virtual user logs in once
+	login := result.Snapshot.Requests["login"]
+	require.Equal(t, 2, login.Total)
+	require.Equal(t, 2, login.Successful)
+
+	// A request in flight when the blast ends is canceled
+	items := result.Snapshot.Requests["GET /items"]
+	require.InDelta(t, 20, items.Total, 4)
+	require.Equal(t, items.Total, items.Successful+items.Errors["canceled"])
+	require.Equal(t, uint64(items.Successful), items.Latency.Count)
+
+	require.Equal(t, 2, result.Snapshot.Checks["logged in"].Passed)
+	require.Equal(t, items.Successful, result.Snapshot.Checks["items listed"].Passed)
+	require.Equal(t, 0, result.Snapshot.Checks["two items"].Failed)
+}
+
+func TestScenarioErrors(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	s, err := script.Parse("failing", `
+		function iteration(vu)
+		  local res = http.get("/items")
+		  check(res.status == 200, "authorized")
+		  if vu.iteration % 2 == 1 then
+		    error("odd iteration")
+		  end
+		end`)
+	require.NoError(t, err)
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 10, 0, nil)
+	require.NoError(t, err)
+	config.Duration = time.Second
+	config.Scenario = s
+
+	result := run(t, config, 1)
+
+	items := result.Snapshot.Requests["GET /items"]
+	require.Equal(t, items.Total, items.Status[http.StatusUnauthorized]+items.Errors["canceled"])
+	require.Equal(t, items.Status[http.StatusUnauthorized], result.Snapshot.Checks["authorized"].Failed)
+
+	failed := result.Snapshot.Requests[blaster.ScenarioRequestName]
+	require.InDelta(t, items.Total/2, failed.Errors["scenario"], 1)
+}
+
+func TestInvalidScripts(t *testing.T) {
+	_, err := script.Parse("syntax", "function iteration(")
+	require.Error(t, err)
+
+	s, err := script.Parse("missing", "function other() end")
+	require.NoError(t, err)
+
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, nil)
+	require.NoError(t, err)
+
+	_, err = s.NewVU(blaster.VUContext{Config: config})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing function iteration")
+}
+
+func TestSharedAndJSON(t *testing.T) {
+	s, err := script.Parse("shared", `
+		function iteration(vu)
+		  local n = shared.get("count") or 0
+		  shared.set("count", n + 1)
+
+		  local decoded = json.decode(json.encode({list = {1, 2, 3}, nested = {ok = true}}))
+		  check(#decoded.list == 3 and decoded.nested.ok, "round trip")
+		  check(json.encode({1, "two"}) == '[1,"two"]', "array")
+		  check(shared.get("count") > n, "count increased")
+		end`)
+	require.NoError(t, err)
+
+	checks := map[string]int{}
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, nil)
+	require.NoError(t, err)
+
+	ctx := blaster.VUContext{
+		Config: config,
+		Check: func(name string, passed bool) {
+			if passed {
+				checks[name]++
+			}
+		},
+	}
+
+	first, err := s.NewVU(ctx)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := s.NewVU(ctx)
+	require.NoError(t, err)
+	defer second.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, first.Iterate(blaster.RequestInfo{Iteration: i}))
+		require.NoError(t, second.Iterate(blaster.RequestInfo{Iteration: i}))
+	}
+
+	require.Equal(t, map[string]int{"round trip": 6, "array": 6, "count increased": 6}, checks)
+}
+
+func TestScenarioNotMarshaled(t *testing.T) {
+	s, err := script.Parse("empty", "function iteration(vu) end")
+	require.NoError(t, err)
+
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, nil)
+	require.NoError(t, err)
+	config.Scenario = s
+
+	_, err = json.Marshal(config)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "a scenario can't be sent to another process")
+}
+
+func TestSleepInterrupted(t *testing.T) {
+	s, err := script.Parse("sleeping", `
+		function iteration(vu)
+		  sleep(10)
+		  check(true, "woke up")
+		end`)
+	require.NoError(t, err)
+
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, nil)
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+	config.Scenario = s
+
+	// The blaster stops when its duration has passed, not when the
+	// sleep is done, and the rest of the iteration is skipped
+	start := time.Now()
+	result := run(t, config, 1)
+	require.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	require.Nil(t, result.Snapshot.Checks["woke up"])
+	require.Nil(t, result.Snapshot.Requests[blaster.ScenarioRequestName])
+}
+
+func TestRequestInterrupted(t *testing.T) {
+	// A target that never responds
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s, err := script.Parse("hanging", `
+		function iteration(vu)
+		  http.get("/hang")
+		end`)
+	require.NoError(t, err)
+
+	config, err := blaster.NewConfiguration(server.URL, http.MethodGet, 10, 0, nil)
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+	config.Scenario = s
+
+	// The request is canceled when the blaster stops
+	start := time.Now()
+	result := run(t, config, 1)
+	require.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	require.Equal(t, 1, result.Snapshot.Requests["GET /hang"].Errors["canceled"])
+}
+
+func TestBusyLoopInterrupted(t *testing.T) {
+	s, err := script.Parse("busy", `
+		function iteration(vu)
+		  while true do end
+		end`)
+	require.NoError(t, err)
+
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, nil)
+	require.NoError(t, err)
+	config.Duration = 500 * time.Millisecond
+	config.Scenario = s
+
+	// The loop is interrupted when the blaster stops, without failing
+	start := time.Now()
+	result := run(t, config, 1)
+	require.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	require.Nil(t, result.Snapshot.Requests[blaster.ScenarioRequestName])
+}
+
+func TestLibraries(t *testing.T) {
+	s, err := script.Parse("libraries", `
+		function iteration(vu)
+		  check(string.upper("a") == "A" and math.max(1, 2) == 2 and #table.concat({"a", "b"}) == 2, "available")
+		  check(os == nil and io == nil and dofile == nil and loadfile == nil and require == nil, "left out")
+		end`)
+	require.NoError(t, err)
+
+	checks := map[string]bool{}
+	config, err := blaster.NewConfiguration("http://localhost", http.MethodGet, 10, 0, nil)
+	require.NoError(t, err)
+	vu, err := s.NewVU(blaster.VUContext{
+		Config: config,
+		Check:  func(name string, passed bool) { checks[name] = passed },
+	})
+	require.NoError(t, err)
+	defer vu.Close()
+
+	require.NoError(t, vu.Iterate(blaster.RequestInfo{}))
+	require.Equal(t, map[string]bool{"available": true, "left out": true}, checks)
+}
@@ -0,0 +1,15 @@
+-- Logs in once per virtual user and then lists the items
+function iteration(vu)
+  if token == nil then
+    local res = http.post("/login", json.encode({user = vu.id}), {name = "login"})
+    check(res.status == 200, "logged in")
+    token = json.decode(res.body).token
+    shared.set("last_user", vu.id)
+  end
+
+  local res = http.get("/items", {headers = {Authorization = "Bearer " .. token}})
+  check(res.status == 200, "items listed")
+
+  local items = json.decode(res.body)
+  check(#items == 2 and items[2].name == "second", "two items")
+end